@@ -0,0 +1,127 @@
+// Package ai talks to the AI provider whose tokens users store in their
+// settings.
+package ai
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields of the settings that ValidateSettings reports on.
+const (
+	FieldToken        = "ai_token"
+	FieldGPTModel     = "gpt_model"
+	FieldWhisperModel = "whisper_model"
+	FieldTTSModel     = "tts_model"
+)
+
+// Provider is an AI API that user tokens are issued for.
+type Provider interface {
+	// ListModels returns the IDs of the models the token can use. It returns
+	// ErrInvalidToken when the provider rejects the token.
+	ListModels(ctx context.Context, token string) ([]string, error)
+}
+
+var (
+	providerFlag = flag.String("aiProvider", "openai", "AI provider that settings are checked against on save: openai or none")
+	baseURLFlag  = flag.String("aiProviderBaseURL", "https://api.openai.com/v1", "Base URL of the OpenAI compatible API")
+	timeoutFlag  = flag.Duration("aiProviderTimeout", 10*time.Second, "Timeout of calls to the AI provider")
+
+	ErrInvalidToken        = errors.New("token was rejected by the AI provider")
+	ErrProviderUnavailable = errors.New("AI provider is unavailable")
+
+	provider     Provider
+	providerErr  error
+	providerOnce sync.Once
+)
+
+// GetProvider returns the configured provider, or nil when checks are
+// disabled.
+func GetProvider() (Provider, error) {
+	providerOnce.Do(func() {
+		switch *providerFlag {
+		case "openai":
+			provider = NewOpenAIProvider(*baseURLFlag, &http.Client{Timeout: *timeoutFlag})
+		case "none":
+		default:
+			providerErr = fmt.Errorf("unsupported AI provider: %s", *providerFlag)
+		}
+	})
+	return provider, providerErr
+}
+
+// FieldError is one rejected settings field, for the frontend to point at.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error lists every rejected field.
+type Error struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+": "+f.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Settings are the values of one settings entry that depend on the provider.
+type Settings struct {
+	Token        string
+	GPTModel     string
+	WhisperModel string
+	TTSModel     string
+}
+
+// ValidateSettings checks that the token works and that every model that is
+// set is available to it. It returns an *Error for rejected fields and
+// wraps ErrProviderUnavailable when the provider cannot tell.
+func ValidateSettings(ctx context.Context, p Provider, s Settings) error {
+	modelFields := []struct{ field, model string }{
+		{FieldGPTModel, s.GPTModel},
+		{FieldWhisperModel, s.WhisperModel},
+		{FieldTTSModel, s.TTSModel},
+	}
+
+	if s.Token == "" {
+		for _, f := range modelFields {
+			if f.model != "" {
+				return &Error{Fields: []FieldError{{Field: FieldToken, Message: "token is required to check the models"}}}
+			}
+		}
+		return nil
+	}
+
+	available, err := p.ListModels(ctx, s.Token)
+	if errors.Is(err, ErrInvalidToken) {
+		return &Error{Fields: []FieldError{{Field: FieldToken, Message: err.Error()}}}
+	}
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(available))
+	for _, id := range available {
+		known[id] = true
+	}
+
+	var rejected []FieldError
+	for _, f := range modelFields {
+		if f.model != "" && !known[f.model] {
+			rejected = append(rejected, FieldError{Field: f.field, Message: fmt.Sprintf("model %q is not available for this token", f.model)})
+		}
+	}
+	if len(rejected) > 0 {
+		return &Error{Fields: rejected}
+	}
+	return nil
+}
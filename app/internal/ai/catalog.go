@@ -0,0 +1,149 @@
+package ai
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Capabilities a catalog groups models by, one per model field of the
+// settings.
+const (
+	CapabilityChat          = "chat"
+	CapabilityTranscription = "transcription"
+	CapabilityTTS           = "tts"
+)
+
+// Model is a catalog entry with what a frontend shows in a model picker.
+type Model struct {
+	ID           string  `json:"id"`
+	DisplayName  string  `json:"display_name"`
+	ContextSize  int     `json:"context_size,omitempty"`
+	PricePerUnit float64 `json:"price_per_unit,omitempty"`
+	PriceUnit    string  `json:"price_unit,omitempty"`
+	Deprecated   bool    `json:"deprecated"`
+}
+
+// Catalog lists the models users may pick, grouped by capability.
+type Catalog struct {
+	Chat          []Model `json:"chat"`
+	Transcription []Model `json:"transcription"`
+	TTS           []Model `json:"tts"`
+}
+
+var (
+	catalogFileFlag = flag.String("aiModelCatalogFile", "", "JSON file with the models users can pick per capability, empty allows any model")
+
+	catalog     *Catalog
+	catalogErr  error
+	catalogOnce sync.Once
+)
+
+// GetCatalog returns the catalog from aiModelCatalogFile, or nil when no
+// file is configured.
+func GetCatalog() (*Catalog, error) {
+	catalogOnce.Do(func() {
+		if *catalogFileFlag == "" {
+			return
+		}
+		catalog, catalogErr = loadCatalog(*catalogFileFlag)
+	})
+	return catalog, catalogErr
+}
+
+func loadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Catalog
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse model catalog %s: %w", path, err)
+	}
+	for capability, group := range c.groups() {
+		seen := make(map[string]bool, len(group))
+		for _, model := range group {
+			if model.ID == "" {
+				return nil, fmt.Errorf("model catalog %s: %s model without id", path, capability)
+			}
+			if seen[model.ID] {
+				return nil, fmt.Errorf("model catalog %s: duplicate %s model %q", path, capability, model.ID)
+			}
+			seen[model.ID] = true
+		}
+	}
+	return &c, nil
+}
+
+func (c *Catalog) groups() map[string][]Model {
+	return map[string][]Model{
+		CapabilityChat:          c.Chat,
+		CapabilityTranscription: c.Transcription,
+		CapabilityTTS:           c.TTS,
+	}
+}
+
+func (c *Catalog) find(capability, id string) (Model, bool) {
+	for _, model := range c.groups()[capability] {
+		if model.ID == id {
+			return model, true
+		}
+	}
+	return Model{}, false
+}
+
+// Available returns the catalog reduced to the models in ids, such as the
+// ones a provider lists for a token.
+func (c *Catalog) Available(ids []string) *Catalog {
+	available := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		available[id] = true
+	}
+	filter := func(group []Model) []Model {
+		kept := []Model{}
+		for _, model := range group {
+			if available[model.ID] {
+				kept = append(kept, model)
+			}
+		}
+		return kept
+	}
+	return &Catalog{
+		Chat:          filter(c.Chat),
+		Transcription: filter(c.Transcription),
+		TTS:           filter(c.TTS),
+	}
+}
+
+// CheckCatalog rejects models that are set in s but are not in the catalog
+// for their capability, or are deprecated. Without a catalog any model passes.
+func CheckCatalog(s Settings) error {
+	c, err := GetCatalog()
+	if err != nil || c == nil {
+		return err
+	}
+
+	var rejected []FieldError
+	for _, f := range []struct{ field, capability, model string }{
+		{FieldGPTModel, CapabilityChat, s.GPTModel},
+		{FieldWhisperModel, CapabilityTranscription, s.WhisperModel},
+		{FieldTTSModel, CapabilityTTS, s.TTSModel},
+	} {
+		if f.model == "" {
+			continue
+		}
+		model, ok := c.find(f.capability, f.model)
+		switch {
+		case !ok:
+			rejected = append(rejected, FieldError{Field: f.field, Message: fmt.Sprintf("model %q is not a supported %s model", f.model, f.capability)})
+		case model.Deprecated:
+			rejected = append(rejected, FieldError{Field: f.field, Message: fmt.Sprintf("model %q is deprecated", f.model)})
+		}
+	}
+	if len(rejected) > 0 {
+		return &Error{Fields: rejected}
+	}
+	return nil
+}
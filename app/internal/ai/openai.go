@@ -0,0 +1,65 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody caps how much of an error response ends up in a log line.
+const maxErrorBody = 512
+
+type openAIProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOpenAIProvider returns a Provider for an OpenAI compatible API at
+// baseURL, e.g. https://api.openai.com/v1 or a local mock server.
+func NewOpenAIProvider(baseURL string, client *http.Client) Provider {
+	return &openAIProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+type openAIModelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+func (p *openAIProvider) ListModels(ctx context.Context, token string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, ErrInvalidToken
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, fmt.Errorf("%w: list models returned %d: %s", ErrProviderUnavailable, resp.StatusCode, body)
+	}
+
+	var list openAIModelList
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("%w: cannot decode model list: %v", ErrProviderUnavailable, err)
+	}
+	ids := make([]string, 0, len(list.Data))
+	for _, model := range list.Data {
+		ids = append(ids, model.ID)
+	}
+	return ids, nil
+}
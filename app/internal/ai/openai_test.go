@@ -0,0 +1,186 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testToken = "sk-test-token"
+
+// newModelServer serves GET /models like the OpenAI API: the model list for
+// testToken and 401 for any other token.
+func newModelServer(t *testing.T, models ...string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"Incorrect API key provided"}}`))
+			return
+		}
+		body := `{"object":"list","data":[`
+		for i, model := range models {
+			if i > 0 {
+				body += ","
+			}
+			body += `{"id":"` + model + `","object":"model"}`
+		}
+		_, _ = w.Write([]byte(body + `]}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestProvider(server *httptest.Server) Provider {
+	return NewOpenAIProvider(server.URL+"/v1/", server.Client())
+}
+
+func fieldErrors(t *testing.T, err error) map[string]string {
+	t.Helper()
+
+	var settingsErr *Error
+	if !errors.As(err, &settingsErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	fields := make(map[string]string)
+	for _, f := range settingsErr.Fields {
+		fields[f.Field] = f.Message
+	}
+	return fields
+}
+
+func TestListModels(t *testing.T) {
+	p := newTestProvider(newModelServer(t, "gpt-4o", "whisper-1"))
+
+	models, err := p.ListModels(context.Background(), testToken)
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if len(models) != 2 || models[0] != "gpt-4o" || models[1] != "whisper-1" {
+		t.Errorf("models = %v", models)
+	}
+}
+
+func TestListModelsStatusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		want   error
+	}{
+		{http.StatusUnauthorized, ErrInvalidToken},
+		{http.StatusForbidden, ErrInvalidToken},
+		{http.StatusTooManyRequests, ErrProviderUnavailable},
+		{http.StatusInternalServerError, ErrProviderUnavailable},
+		{http.StatusBadGateway, ErrProviderUnavailable},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(tc.status)
+		}))
+		_, err := newTestProvider(server).ListModels(context.Background(), testToken)
+		server.Close()
+		if !errors.Is(err, tc.want) {
+			t.Errorf("status %d: err = %v, want %v", tc.status, err, tc.want)
+		}
+	}
+}
+
+func TestListModelsUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	p := newTestProvider(server)
+	server.Close()
+
+	if _, err := p.ListModels(context.Background(), testToken); !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("err = %v, want ErrProviderUnavailable", err)
+	}
+}
+
+func TestListModelsInvalidBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`<html>maintenance</html>`))
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := newTestProvider(server).ListModels(context.Background(), testToken); !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("err = %v, want ErrProviderUnavailable", err)
+	}
+}
+
+func TestValidateSettingsAccepts(t *testing.T) {
+	p := newTestProvider(newModelServer(t, "gpt-4o", "whisper-1", "tts-1"))
+
+	err := ValidateSettings(context.Background(), p, Settings{
+		Token:        testToken,
+		GPTModel:     "gpt-4o",
+		WhisperModel: "whisper-1",
+		TTSModel:     "tts-1",
+	})
+	if err != nil {
+		t.Fatalf("ValidateSettings: %v", err)
+	}
+}
+
+func TestValidateSettingsRejectsToken(t *testing.T) {
+	p := newTestProvider(newModelServer(t, "gpt-4o"))
+
+	fields := fieldErrors(t, ValidateSettings(context.Background(), p, Settings{Token: "sk-wrong", GPTModel: "gpt-4o"}))
+	if _, ok := fields[FieldToken]; !ok || len(fields) != 1 {
+		t.Errorf("fields = %v, want only %s", fields, FieldToken)
+	}
+}
+
+func TestValidateSettingsRejectsUnknownModels(t *testing.T) {
+	p := newTestProvider(newModelServer(t, "gpt-4o", "whisper-1"))
+
+	fields := fieldErrors(t, ValidateSettings(context.Background(), p, Settings{
+		Token:        testToken,
+		GPTModel:     "gpt-4o",
+		WhisperModel: "whisper-2",
+		TTSModel:     "tts-1",
+	}))
+	if len(fields) != 2 {
+		t.Errorf("fields = %v, want %s and %s", fields, FieldWhisperModel, FieldTTSModel)
+	}
+	for _, field := range []string{FieldWhisperModel, FieldTTSModel} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("no error for %s in %v", field, fields)
+		}
+	}
+}
+
+func TestValidateSettingsNeedsTokenForModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("provider was called without a token")
+	}))
+	t.Cleanup(server.Close)
+	p := newTestProvider(server)
+
+	fields := fieldErrors(t, ValidateSettings(context.Background(), p, Settings{GPTModel: "gpt-4o"}))
+	if _, ok := fields[FieldToken]; !ok {
+		t.Errorf("fields = %v, want %s", fields, FieldToken)
+	}
+	if err := ValidateSettings(context.Background(), p, Settings{}); err != nil {
+		t.Errorf("empty settings: %v", err)
+	}
+}
+
+func TestValidateSettingsProviderDown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	err := ValidateSettings(context.Background(), newTestProvider(server), Settings{Token: testToken, GPTModel: "gpt-4o"})
+	if !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("err = %v, want ErrProviderUnavailable", err)
+	}
+	var settingsErr *Error
+	if errors.As(err, &settingsErr) {
+		t.Errorf("an unavailable provider was reported as a field error: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package clients
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+const (
+	ScopeIntrospect = "introspect"
+	ScopeAdmin      = "admin"
+	ScopeAITokens   = "ai_tokens"
+)
+
+var (
+	oauthClientsFlag = flag.String("oauthClients", "", "Comma separated service clients as id:secret:scope1 scope2")
+
+	ErrInvalidClient = errors.New("invalid client credentials")
+
+	registry map[string]*registeredClient
+	once     sync.Once
+)
+
+// Client is a backend service authenticated with client credentials.
+type Client struct {
+	ID     string
+	Scopes []string
+}
+
+func (c *Client) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type registeredClient struct {
+	client     Client
+	secretHash [sha256.Size]byte
+}
+
+func initRegistry() {
+	registry = make(map[string]*registeredClient)
+	for _, entry := range strings.Split(*oauthClientsFlag, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			logrus.Errorf("Skipping malformed OAuth client entry")
+			continue
+		}
+		c := &registeredClient{
+			client:     Client{ID: parts[0]},
+			secretHash: sha256.Sum256([]byte(parts[1])),
+		}
+		if len(parts) == 3 {
+			c.client.Scopes = strings.Fields(parts[2])
+		}
+		registry[c.client.ID] = c
+	}
+}
+
+// Lookup returns a registered client without checking its secret, for
+// callers that were authenticated another way, such as a client certificate.
+func Lookup(id string) (*Client, bool) {
+	once.Do(func() {
+		initRegistry()
+	})
+
+	c, ok := registry[id]
+	if !ok {
+		return nil, false
+	}
+	client := c.client
+	return &client, true
+}
+
+// Authenticate checks client credentials in constant time.
+func Authenticate(id, secret string) (*Client, error) {
+	once.Do(func() {
+		initRegistry()
+	})
+
+	c, ok := registry[id]
+	if !ok {
+		return nil, ErrInvalidClient
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], c.secretHash[:]) != 1 {
+		return nil, ErrInvalidClient
+	}
+	client := c.client
+	return &client, nil
+}
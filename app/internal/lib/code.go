@@ -1,7 +1,11 @@
 package lib
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 )
@@ -11,3 +15,21 @@ func GenerateSecureVerificationCode() string {
 	code := n.Int64() + 10000
 	return fmt.Sprintf("%05d", code)
 }
+
+// HashVerificationCode returns the hex HMAC-SHA256 of the code. Codes have
+// only 90,000 values, so a plain hash would be reversed by enumeration; the
+// server-side pepper keeps a leaked table useless on its own.
+func HashVerificationCode(pepper, code string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerificationCodeMatches compares the code with a stored hash in constant
+// time. An empty hash never matches.
+func VerificationCodeMatches(pepper, code, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashVerificationCode(pepper, code)), []byte(hash)) == 1
+}
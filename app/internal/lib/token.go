@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+func GenerateSecureToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MaskToken hides a secret except for a short vendor prefix such as "sk-"
+// and its last four characters, e.g. "sk-...abcd", which is enough for a user
+// to tell their keys apart.
+func MaskToken(token string) string {
+	if len(token) < 12 {
+		return "..."
+	}
+	prefix := ""
+	if i := strings.Index(token, "-"); i >= 0 && i < 4 {
+		prefix = token[:i+1]
+	}
+	return prefix + "..." + token[len(token)-4:]
+}
+
+// TokenFingerprint identifies a secret without revealing it, so two stored
+// keys can be compared.
+func TokenFingerprint(token string) string {
+	return "sha256:" + HashToken(token)[:16]
+}
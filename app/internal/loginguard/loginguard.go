@@ -0,0 +1,176 @@
+package loginguard
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is the failed-login state of one key, an account or a client IP.
+type Record struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	// Lockouts counts the lockouts since the last successful login or
+	// unlock. Unlike Failures it is not reset when the window passes.
+	Lockouts int
+}
+
+// Store keeps failed-login counters.
+type Store interface {
+	Get(key string) (Record, error)
+	// AddFailure counts a failed login and returns the new record. The count
+	// starts over when the previous failure is older than window.
+	AddFailure(key string, now time.Time, window time.Duration) (Record, error)
+	// Lock locks the key until the given time and counts the lockout.
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// BlockedError is returned while a key is backing off or locked out.
+type BlockedError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *BlockedError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account is temporarily locked, retry in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+var (
+	loginGuardBackend  = flag.String("loginGuardStore", "mysql", "Failed login counter backend: mysql or memory")
+	maxAccountFailures = flag.Int("loginMaxFailures", 10, "Failed logins in loginFailureWindow after which an account is locked")
+	maxIPFailures      = flag.Int("loginIPMaxFailures", 100, "Failed logins in loginFailureWindow after which a client IP is locked")
+	failureWindow      = flag.Duration("loginFailureWindow", 15*time.Minute, "Failed logins older than this no longer count")
+	lockoutDuration    = flag.Duration("loginLockoutDuration", 15*time.Minute, "First lockout duration, doubled for every further lockout until a successful login or unlock")
+	maxLockoutDuration = flag.Duration("loginMaxLockoutDuration", 24*time.Hour, "Upper bound of the lockout duration")
+	lockoutMemory      = flag.Duration("loginLockoutMemory", 7*24*time.Hour, "How long past lockouts are remembered without any further failed login")
+	backoffBase        = flag.Duration("loginBackoffBase", time.Second, "Delay required after the first failed login, doubled for every further failure")
+	maxBackoff         = flag.Duration("loginMaxBackoff", time.Minute, "Upper bound of the delay between failed logins")
+	store              Store
+	once               sync.Once
+)
+
+func initStore() {
+	switch *loginGuardBackend {
+	case "mysql":
+		store = newMySQLStore()
+	case "memory":
+		store = newMemoryStore(*failureWindow, *lockoutMemory)
+	default:
+		logrus.Fatalf("Unknown login guard store: %s", *loginGuardBackend)
+	}
+}
+
+func GetStore() Store {
+	once.Do(func() {
+		initStore()
+	})
+
+	return store
+}
+
+// Check returns a *BlockedError when the account or the client IP has to
+// wait before the next login attempt.
+func Check(email, ip string) error {
+	now := time.Now()
+	for _, key := range keys(email, ip) {
+		record, err := GetStore().Get(key)
+		if err != nil {
+			return err
+		}
+		if now.Before(record.LockedUntil) {
+			return &BlockedError{RetryAfter: record.LockedUntil.Sub(now), Locked: true}
+		}
+		if record.Failures == 0 || now.Sub(record.LastFailure) >= *failureWindow {
+			continue
+		}
+		if next := record.LastFailure.Add(backoff(record.Failures)); now.Before(next) {
+			return &BlockedError{RetryAfter: next.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// Fail records a failed login for the account and the client IP and locks
+// whichever crossed its threshold. Every lockout lasts twice as long as the
+// previous one. It reports whether the account was locked.
+func Fail(email, ip string) (bool, error) {
+	now := time.Now()
+	accountLocked := false
+	for _, key := range keys(email, ip) {
+		record, err := GetStore().AddFailure(key, now, *failureWindow)
+		if err != nil {
+			return false, err
+		}
+		threshold := *maxIPFailures
+		if key == AccountKey(email) {
+			threshold = *maxAccountFailures
+		}
+		if record.Failures < threshold {
+			continue
+		}
+		if err = GetStore().Lock(key, now.Add(lockout(record.Lockouts))); err != nil {
+			return false, err
+		}
+		if key == AccountKey(email) {
+			accountLocked = true
+		}
+	}
+	return accountLocked, nil
+}
+
+// Succeed clears the account counter after a successful login. The IP
+// counter is kept so that one valid account does not reset an attacker's IP.
+func Succeed(email string) error {
+	return GetStore().Reset(AccountKey(email))
+}
+
+func UnlockAccount(email string) error {
+	return GetStore().Reset(AccountKey(email))
+}
+
+func UnlockIP(ip string) error {
+	return GetStore().Reset(ipKey(ip))
+}
+
+func keys(email, ip string) []string {
+	if ip == "" {
+		return []string{AccountKey(email)}
+	}
+	return []string{AccountKey(email), ipKey(ip)}
+}
+
+// AccountKey is the key of the failure counter of an account.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func backoff(failures int) time.Duration {
+	return doubled(*backoffBase, failures-1, *maxBackoff)
+}
+
+func lockout(previousLockouts int) time.Duration {
+	return doubled(*lockoutDuration, previousLockouts, *maxLockoutDuration)
+}
+
+func doubled(base time.Duration, times int, limit time.Duration) time.Duration {
+	d := base
+	for i := 0; i < times && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
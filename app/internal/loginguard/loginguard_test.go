@@ -0,0 +1,110 @@
+package loginguard
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	for name, value := range map[string]string{
+		"loginGuardStore":      "memory",
+		"loginMaxFailures":     "3",
+		"loginFailureWindow":   "15m",
+		"loginLockoutDuration": "15m",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// expireLock ends the current lockout and moves the failures out of the
+// window, as if the user came back after waiting it out.
+func expireLock(t *testing.T, email string) {
+	t.Helper()
+
+	s := GetStore().(*memoryStore)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.records[AccountKey(email)]
+	record.LockedUntil = time.Now().Add(-time.Second)
+	record.LastFailure = time.Now().Add(-*failureWindow - time.Second)
+	s.records[AccountKey(email)] = record
+}
+
+func failUntilLocked(t *testing.T, email string) time.Duration {
+	t.Helper()
+
+	for i := 0; i < *maxAccountFailures; i++ {
+		locked, err := Fail(email, "")
+		if err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		if locked != (i == *maxAccountFailures-1) {
+			t.Fatalf("failure %d: locked = %v", i+1, locked)
+		}
+	}
+
+	var blocked *BlockedError
+	if err := Check(email, ""); !errors.As(err, &blocked) || !blocked.Locked {
+		t.Fatalf("Check = %v, want a lockout", err)
+	}
+	return blocked.RetryAfter
+}
+
+func assertAbout(t *testing.T, got, want time.Duration) {
+	t.Helper()
+
+	if got > want || got < want-time.Minute {
+		t.Errorf("lockout = %s, want about %s", got, want)
+	}
+}
+
+func TestLockoutDoublesAcrossWindows(t *testing.T) {
+	email := "escalate@example.com"
+
+	assertAbout(t, failUntilLocked(t, email), 15*time.Minute)
+	expireLock(t, email)
+	assertAbout(t, failUntilLocked(t, email), 30*time.Minute)
+	expireLock(t, email)
+	assertAbout(t, failUntilLocked(t, email), time.Hour)
+}
+
+func TestLockoutIsCapped(t *testing.T) {
+	email := "capped@example.com"
+
+	for i := 0; i < 10; i++ {
+		failUntilLocked(t, email)
+		expireLock(t, email)
+	}
+	assertAbout(t, failUntilLocked(t, email), *maxLockoutDuration)
+}
+
+func TestSucceedResetsLockouts(t *testing.T) {
+	email := "reset@example.com"
+
+	failUntilLocked(t, email)
+	expireLock(t, email)
+	if err := Succeed(email); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+	assertAbout(t, failUntilLocked(t, email), 15*time.Minute)
+}
+
+func TestUnlockAccountResetsLockouts(t *testing.T) {
+	email := "unlock@example.com"
+
+	failUntilLocked(t, email)
+	if err := UnlockAccount(email); err != nil {
+		t.Fatalf("UnlockAccount: %v", err)
+	}
+	if err := Check(email, ""); err != nil {
+		t.Fatalf("Check after unlock = %v", err)
+	}
+	assertAbout(t, failUntilLocked(t, email), 15*time.Minute)
+}
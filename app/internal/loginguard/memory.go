@@ -0,0 +1,85 @@
+package loginguard
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryStore keeps counters in process memory. It suits a single instance;
+// with several instances every one of them counts on its own.
+type memoryStore struct {
+	window time.Duration
+	memory time.Duration
+
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func newMemoryStore(window, memory time.Duration) *memoryStore {
+	s := &memoryStore{
+		window:  window,
+		memory:  memory,
+		records: make(map[string]Record),
+	}
+	go s.evictStale()
+	return s
+}
+
+func (s *memoryStore) Get(key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+func (s *memoryStore) AddFailure(key string, now time.Time, window time.Duration) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.records[key]
+	if now.Sub(record.LastFailure) > window {
+		record.Failures = 0
+	}
+	record.Failures++
+	record.LastFailure = now
+	s.records[key] = record
+	return record, nil
+}
+
+func (s *memoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.records[key]
+	if until.After(record.LockedUntil) {
+		record.LockedUntil = until
+	}
+	record.Lockouts++
+	s.records[key] = record
+	return nil
+}
+
+func (s *memoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryStore) evictStale() {
+	ticker := time.NewTicker(s.window)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for key, record := range s.records {
+			keep := s.window
+			if record.Lockouts > 0 {
+				keep = s.memory
+			}
+			if now.Sub(record.LastFailure) > keep && now.After(record.LockedUntil) {
+				delete(s.records, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,65 @@
+package loginguard
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"time"
+)
+
+const purgeInterval = time.Hour
+
+type mysqlStore struct {
+	storage *mysql.Storage
+}
+
+func newMySQLStore() *mysqlStore {
+	s := &mysqlStore{
+		storage: mysql.GetConnection(),
+	}
+	go s.purgeStale()
+	return s
+}
+
+func (s *mysqlStore) Get(key string) (Record, error) {
+	failures, err := s.storage.GetLoginFailures(key)
+	if err != nil {
+		return Record{}, err
+	}
+	return toRecord(failures), nil
+}
+
+func (s *mysqlStore) AddFailure(key string, now time.Time, window time.Duration) (Record, error) {
+	failures, err := s.storage.AddLoginFailure(key, now.Unix(), now.Add(-window).Unix())
+	if err != nil {
+		return Record{}, err
+	}
+	return toRecord(failures), nil
+}
+
+func (s *mysqlStore) Lock(key string, until time.Time) error {
+	return s.storage.LockLogin(key, until.Unix())
+}
+
+func (s *mysqlStore) Reset(key string) error {
+	return s.storage.DeleteLoginFailures(key)
+}
+
+func (s *mysqlStore) purgeStale() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		_ = s.storage.DeleteStaleLoginFailures(now.Add(-*failureWindow).Unix(), now.Add(-*lockoutMemory).Unix(), now.Unix())
+	}
+}
+
+func toRecord(failures *models.LoginFailures) Record {
+	record := Record{Failures: failures.Failures, Lockouts: failures.Lockouts}
+	if failures.LastFailureAt > 0 {
+		record.LastFailure = time.Unix(failures.LastFailureAt, 0)
+	}
+	if failures.LockedUntil > 0 {
+		record.LockedUntil = time.Unix(failures.LockedUntil, 0)
+	}
+	return record
+}
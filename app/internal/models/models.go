@@ -10,10 +10,57 @@ type UserData struct {
 	ID       int    `json:"id"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	Code     string `json:"code"`
 	IsVerify bool   `json:"is_verify"`
 }
 
+type OneTimeCode struct {
+	ID      int
+	Hash    string
+	Payload string
+}
+
+type LoginFailures struct {
+	Failures      int
+	LastFailureAt int64
+	LockedUntil   int64
+	Lockouts      int
+}
+
+type UnlockReq struct {
+	Email string `json:"email,omitempty"`
+	IP    string `json:"ip,omitempty"`
+}
+
+type LoginEvent struct {
+	Method    string `json:"method"`
+	IP        string `json:"ip"`
+	Success   bool   `json:"success"`
+	CreatedAt string `json:"created_at"`
+}
+
+type AuditEvent struct {
+	Event     string `json:"event"`
+	Detail    string `json:"detail,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AccountExport is everything the service stores about a user, as returned
+// by the data export. AI tokens are masked.
+type AccountExport struct {
+	Profile      Profile         `json:"profile"`
+	Settings     []*UserSettings `json:"ai_settings"`
+	Passkeys     []*Passkey      `json:"passkeys"`
+	LoginHistory []*LoginEvent   `json:"login_history"`
+	AuditEvents  []*AuditEvent   `json:"audit_events"`
+}
+
+type Profile struct {
+	ID               int    `json:"id"`
+	Email            string `json:"email"`
+	IsVerified       bool   `json:"is_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+}
+
 type UserSettings struct {
 	ID           int    `json:"id"`
 	Name         string `json:"name"`
@@ -22,4 +69,117 @@ type UserSettings struct {
 	WhisperModel string `json:"whisper_model"`
 	TTSModel     string `json:"tts_model"`
 	GPTModel     string `json:"gpt_model"`
+	// TokenFingerprint is set when AIToken is masked.
+	TokenFingerprint string `json:"ai_token_fingerprint,omitempty"`
+	// TokenKeyID and TokenDataKey describe how AIToken is encrypted in
+	// storage. Both are empty for a token that is still stored in plaintext.
+	TokenKeyID   string `json:"-"`
+	TokenDataKey string `json:"-"`
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type RefreshTokenReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	Email     string
+	FamilyID  string
+	ExpiresIn int64
+	IsExpired bool
+	IsUsed    bool
+	IsRevoked bool
+}
+
+// ServiceCaller is a backend service calling the internal API.
+type ServiceCaller struct {
+	ClientID string
+	// AuthMethod is "mtls" or "token".
+	AuthMethod string
+	IP         string
+}
+
+type ClientToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+type LoginResponse struct {
+	*TokenPair
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailReq struct {
+	NewEmail string `json:"new_email"`
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+type TokenReq struct {
+	Token string `json:"token"`
+}
+
+type MFAReq struct {
+	MFAToken     string `json:"mfa_token,omitempty"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	Password     string `json:"password,omitempty"`
+}
+
+type TOTPSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRPNG      []byte `json:"qr_png"`
+}
+
+type TOTP struct {
+	UserID       int
+	Secret       string
+	IsConfirmed  bool
+	LastUsedStep int64
+}
+
+type Passkey struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+type PasskeyReq struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+type WebAuthnCeremony struct {
+	SessionID string      `json:"session_id"`
+	Options   interface{} `json:"options"`
 }
@@ -0,0 +1,92 @@
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+var (
+	argon2Memory      = flag.Uint("argon2Memory", 64*1024, "argon2id memory in KiB for new password hashes")
+	argon2Iterations  = flag.Uint("argon2Iterations", 3, "argon2id iterations for new password hashes")
+	argon2Parallelism = flag.Uint("argon2Parallelism", 2, "argon2id parallelism for new password hashes")
+)
+
+const (
+	argon2SaltLength    = 16
+	argon2MinSaltLength = 8
+	argon2KeyLength     = 32
+)
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func currentArgon2Params() argon2Params {
+	return argon2Params{
+		memory:      uint32(*argon2Memory),
+		iterations:  uint32(*argon2Iterations),
+		parallelism: uint8(*argon2Parallelism),
+	}
+}
+
+func (p argon2Params) weakerThan(other argon2Params) bool {
+	return p.memory < other.memory || p.iterations < other.iterations || p.parallelism < other.parallelism
+}
+
+func hashArgon2id(password string, params argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgArgon2id, argon2.Version, params.memory, params.iterations, params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func verifyArgon2id(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgArgon2id {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	// argon2.IDKey panics on zero parameters.
+	if params.memory == 0 || params.iterations == 0 || params.parallelism == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2MinSaltLength {
+		return params, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
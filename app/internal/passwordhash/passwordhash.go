@@ -0,0 +1,120 @@
+// Package passwordhash hashes passwords into self-describing strings, so the
+// algorithm and its cost can change without invalidating stored hashes.
+//
+// New hashes use argon2id in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// bcrypt is kept for hashes written before argon2id was introduced, and can
+// still be selected for new ones. bcrypt only reads the first 72 bytes of a
+// password, so new bcrypt hashes are taken over the SHA-256 of the password
+// and marked with a $bcrypt-sha256 prefix.
+package passwordhash
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	AlgArgon2id = "argon2id"
+	AlgBcrypt   = "bcrypt"
+
+	// MaxPasswordLength bounds the work a single login can cause.
+	MaxPasswordLength = 1024
+
+	bcryptMaxPasswordLength = 72
+	bcryptSHA256Prefix      = "$bcrypt-sha256"
+)
+
+var (
+	algorithm  = flag.String("passwordHashAlg", AlgArgon2id, "Algorithm for new password hashes: argon2id or bcrypt")
+	bcryptCost = flag.Int("bcryptCost", bcrypt.DefaultCost, "bcrypt cost for new password hashes")
+
+	ErrPasswordTooLong = errors.New("password is too long")
+	ErrUnknownHash     = errors.New("unknown password hash format")
+)
+
+// Hash hashes the password with the configured algorithm and parameters.
+func Hash(password string) (string, error) {
+	if len(password) > MaxPasswordLength {
+		return "", ErrPasswordTooLong
+	}
+	switch *algorithm {
+	case AlgBcrypt:
+		hash, err := bcrypt.GenerateFromPassword(prehash(password), *bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return bcryptSHA256Prefix + string(hash), nil
+	default:
+		return hashArgon2id(password, currentArgon2Params())
+	}
+}
+
+// Verify reports whether the password matches the encoded hash. An error
+// means the hash itself could not be read.
+func Verify(encoded, password string) (bool, error) {
+	if len(password) > MaxPasswordLength {
+		return false, nil
+	}
+	switch {
+	case strings.HasPrefix(encoded, "$"+AlgArgon2id+"$"):
+		return verifyArgon2id(encoded, password)
+	case strings.HasPrefix(encoded, bcryptSHA256Prefix+"$"):
+		return compareBcrypt(strings.TrimPrefix(encoded, bcryptSHA256Prefix), prehash(password))
+	case isBcrypt(encoded):
+		// Legacy hashes were taken over the raw password, which bcrypt
+		// truncates. Longer passwords would otherwise match on their prefix.
+		if len(password) > bcryptMaxPasswordLength {
+			return false, nil
+		}
+		return compareBcrypt(encoded, []byte(password))
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or
+// weaker parameters than the configured ones.
+func NeedsRehash(encoded string) bool {
+	switch *algorithm {
+	case AlgBcrypt:
+		if !strings.HasPrefix(encoded, bcryptSHA256Prefix+"$") {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(strings.TrimPrefix(encoded, bcryptSHA256Prefix)))
+		return err != nil || cost < *bcryptCost
+	default:
+		params, _, _, err := decodeArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+		return params.weakerThan(currentArgon2Params())
+	}
+}
+
+// prehash turns a password of any length into 44 bytes for bcrypt.
+func prehash(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func compareBcrypt(hash string, password []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,204 @@
+package passwordhash
+
+import (
+	"errors"
+	"flag"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	for name, value := range map[string]string{
+		"argon2Memory":      "1024",
+		"argon2Iterations":  "1",
+		"argon2Parallelism": "1",
+		"bcryptCost":        "4",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("set %s: %v", name, err)
+	}
+	t.Cleanup(func() { _ = flag.Set(name, previous) })
+}
+
+func mustHash(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := Hash(password)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return hash
+}
+
+func assertVerify(t *testing.T, hash, password string, want bool) {
+	t.Helper()
+
+	ok, err := Verify(hash, password)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if ok != want {
+		t.Errorf("Verify(%.20q...) = %v, want %v", password, ok, want)
+	}
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	hash := mustHash(t, "correct horse battery staple")
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash = %q, want argon2id PHC string with the configured parameters", hash)
+	}
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if params != (argon2Params{memory: 1024, iterations: 1, parallelism: 1}) {
+		t.Errorf("params = %+v", params)
+	}
+	if len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("salt = %d bytes, key = %d bytes", len(salt), len(key))
+	}
+	if hash == mustHash(t, "correct horse battery staple") {
+		t.Error("two hashes of the same password share a salt")
+	}
+
+	assertVerify(t, hash, "correct horse battery staple", true)
+	assertVerify(t, hash, "correct horse battery stapler", false)
+	assertVerify(t, hash, "", false)
+}
+
+func TestVerifyRejectsMalformedHashes(t *testing.T) {
+	valid := mustHash(t, "password")
+	parts := strings.Split(valid, "$")
+	replace := func(i int, value string) string {
+		changed := append([]string{}, parts...)
+		changed[i] = value
+		return strings.Join(changed, "$")
+	}
+
+	for name, encoded := range map[string]string{
+		"empty":            "",
+		"plaintext":        "password",
+		"unknown alg":      replace(1, "argon2i"),
+		"missing part":     strings.Join(parts[:5], "$"),
+		"extra part":       valid + "$extra",
+		"bad version":      replace(2, "v=16"),
+		"no version":       replace(2, "19"),
+		"bad params":       replace(3, "m=1024,t=1"),
+		"zero memory":      replace(3, "m=0,t=1,p=1"),
+		"zero iterations":  replace(3, "m=1024,t=0,p=1"),
+		"zero parallelism": replace(3, "m=1024,t=1,p=0"),
+		"bad salt":         replace(4, "not base64!"),
+		"empty salt":       replace(4, ""),
+		"bad key":          replace(5, "not base64!"),
+		"empty key":        replace(5, ""),
+	} {
+		ok, err := Verify(encoded, "password")
+		if ok || !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("%s: Verify = %v, %v, want ErrUnknownHash", name, ok, err)
+		}
+	}
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("legacy-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	assertVerify(t, string(legacy), "legacy-password", true)
+	assertVerify(t, string(legacy), "Legacy-password", false)
+	if !NeedsRehash(string(legacy)) {
+		t.Error("a legacy bcrypt hash does not need a rehash to argon2id")
+	}
+}
+
+// bcrypt only reads 72 bytes; legacy hashes must not accept a longer
+// password because its first 72 bytes match.
+func TestVerifyLegacyBcryptLongPassword(t *testing.T) {
+	prefix := strings.Repeat("a", bcryptMaxPasswordLength)
+	legacy, err := bcrypt.GenerateFromPassword([]byte(prefix), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	assertVerify(t, string(legacy), prefix, true)
+	assertVerify(t, string(legacy), prefix+"b", false)
+}
+
+func TestBcryptSHA256Prehash(t *testing.T) {
+	setFlag(t, "passwordHashAlg", AlgBcrypt)
+
+	long := strings.Repeat("a", 100)
+	hash := mustHash(t, long)
+	if !strings.HasPrefix(hash, bcryptSHA256Prefix+"$2a$04$") {
+		t.Fatalf("hash = %q, want a bcrypt-sha256 hash with cost 4", hash)
+	}
+
+	assertVerify(t, hash, long, true)
+	// Passwords that only differ after byte 72 are told apart.
+	assertVerify(t, hash, strings.Repeat("a", 99)+"b", false)
+	assertVerify(t, hash, long[:bcryptMaxPasswordLength], false)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current := mustHash(t, "password")
+	if NeedsRehash(current) {
+		t.Error("a hash with the configured parameters needs a rehash")
+	}
+
+	setFlag(t, "argon2Iterations", "2")
+	if !NeedsRehash(current) {
+		t.Error("a hash with fewer iterations does not need a rehash")
+	}
+	stronger := mustHash(t, "password")
+
+	setFlag(t, "argon2Iterations", "1")
+	if NeedsRehash(stronger) {
+		t.Error("a hash with stronger parameters needs a rehash")
+	}
+
+	setFlag(t, "argon2Memory", "2048")
+	if !NeedsRehash(current) {
+		t.Error("a hash with less memory does not need a rehash")
+	}
+
+	setFlag(t, "passwordHashAlg", AlgBcrypt)
+	if !NeedsRehash(current) {
+		t.Error("an argon2id hash does not need a rehash to bcrypt")
+	}
+	bcryptHash := mustHash(t, "password")
+	if NeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash with the configured cost needs a rehash")
+	}
+	setFlag(t, "bcryptCost", "5")
+	if !NeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash with a lower cost does not need a rehash")
+	}
+}
+
+func TestMaxPasswordLength(t *testing.T) {
+	if _, err := Hash(strings.Repeat("a", MaxPasswordLength+1)); !errors.Is(err, ErrPasswordTooLong) {
+		t.Errorf("Hash of an over-long password: err = %v, want ErrPasswordTooLong", err)
+	}
+
+	longest := strings.Repeat("a", MaxPasswordLength)
+	hash := mustHash(t, longest)
+	assertVerify(t, hash, longest, true)
+	// Verify does not even hash an over-long password.
+	assertVerify(t, hash, longest+"a", false)
+}
@@ -0,0 +1,67 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const rangePrefixLength = 5
+
+var (
+	breachedPasswordsDir = flag.String("breachedPasswordsDir", "", "Directory of Pwned Passwords range files named by their 5 character SHA-1 prefix, empty disables the check")
+	breachedMinCount     = flag.Int("breachedPasswordMinCount", 1, "Times a password must appear in breaches to be rejected")
+)
+
+// isBreached looks the password up in a local copy of the Pwned Passwords
+// range files. Like the range API, each file holds the "SUFFIX:COUNT" lines
+// of the SHA-1 hashes that start with the file's prefix, so only one small
+// file is read per check.
+func isBreached(password string) (bool, error) {
+	if *breachedPasswordsDir == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:rangePrefixLength], hash[rangePrefixLength:]
+
+	file, err := openRange(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return false, err
+		}
+		return n >= *breachedMinCount, nil
+	}
+	return false, scanner.Err()
+}
+
+// openRange accepts range files with or without a .txt extension.
+func openRange(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(*breachedPasswordsDir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(*breachedPasswordsDir, prefix))
+	}
+	return file, err
+}
@@ -0,0 +1,102 @@
+// Package passwordpolicy decides whether a new password is acceptable.
+package passwordpolicy
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/passwordhash"
+	"github.com/nbutton23/zxcvbn-go"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules reported in a Violation.
+const (
+	RuleMinLength     = "min_length"
+	RuleMaxLength     = "max_length"
+	RuleContainsEmail = "contains_email"
+	RuleStrength      = "strength"
+	RuleBreached      = "breached"
+)
+
+// strengthInputLimit caps the characters the strength estimate looks at. Its
+// cost grows quickly with length and long passwords are strong anyway.
+const strengthInputLimit = 64
+
+// minLocalPartLength keeps short local-parts such as "al" from rejecting
+// half of all passwords.
+const minLocalPartLength = 3
+
+var (
+	minLength   = flag.Int("passwordMinLength", 10, "Minimum password length in characters")
+	minStrength = flag.Int("passwordMinStrength", 2, "Minimum zxcvbn score from 0 to 4, negative disables the estimate")
+)
+
+// Violation is one failed rule, for the frontend to point at.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error lists every rule a password failed.
+type Error struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Check returns an *Error with all violations, or nil when the password is
+// acceptable for the account with the given email.
+func Check(password, email string) error {
+	var violations []Violation
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < *minLength {
+		add(RuleMinLength, "password must be at least %d characters long", *minLength)
+	}
+	if len(password) > passwordhash.MaxPasswordLength {
+		add(RuleMaxLength, "password must be at most %d bytes long", passwordhash.MaxPasswordLength)
+		return &Error{Violations: violations}
+	}
+
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	if utf8.RuneCountInString(localPart) >= minLocalPartLength && strings.Contains(strings.ToLower(password), localPart) {
+		add(RuleContainsEmail, "password must not contain your email address")
+	}
+
+	if *minStrength >= 0 {
+		if score := strength(password, email, localPart); score < *minStrength {
+			add(RuleStrength, "password is too easy to guess")
+		}
+	}
+
+	breached, err := isBreached(password)
+	if err != nil {
+		logrus.Errorf("Cannot check breached passwords: %v", err)
+	}
+	if breached {
+		add(RuleBreached, "password has appeared in a data breach")
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+func strength(password string, userInputs ...string) int {
+	if utf8.RuneCountInString(password) > strengthInputLimit {
+		password = string([]rune(password)[:strengthInputLimit])
+	}
+	return zxcvbn.PasswordStrength(password, userInputs).Score
+}
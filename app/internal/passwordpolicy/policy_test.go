@@ -0,0 +1,183 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/passwordhash"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const strongPassword = "violet-harbor-tundra-41"
+
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("set %s: %v", name, err)
+	}
+	t.Cleanup(func() { _ = flag.Set(name, previous) })
+}
+
+// writeRange stores a Pwned Passwords range file for the password with the
+// given count, next to a decoy line of another hash with the same prefix.
+func writeRange(t *testing.T, dir, password, count, ext string) {
+	t.Helper()
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:rangePrefixLength], hash[rangePrefixLength:]
+	decoy := strings.Repeat("0", len(suffix))
+
+	data := decoy + ":1000\r\n" + strings.ToLower(suffix) + ":" + count + "\r\n"
+	if err := os.WriteFile(filepath.Join(dir, prefix+ext), []byte(data), 0o644); err != nil {
+		t.Fatalf("write range file: %v", err)
+	}
+}
+
+func violations(t *testing.T, err error) map[string]bool {
+	t.Helper()
+
+	rules := make(map[string]bool)
+	if err == nil {
+		return rules
+	}
+	var policyErr *Error
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	for _, v := range policyErr.Violations {
+		rules[v.Rule] = true
+	}
+	return rules
+}
+
+func TestIsBreached(t *testing.T) {
+	setFlag(t, "breachedPasswordMinCount", "3")
+
+	for _, ext := range []string{"", ".txt"} {
+		dir := t.TempDir()
+		setFlag(t, "breachedPasswordsDir", dir)
+		writeRange(t, dir, "below-the-limit", "2", ext)
+		writeRange(t, dir, "at-the-limit", "3", ext)
+
+		for password, want := range map[string]bool{
+			"below-the-limit": false,
+			"at-the-limit":    true,
+			"not-in-a-range":  false,
+		} {
+			breached, err := isBreached(password)
+			if err != nil {
+				t.Fatalf("isBreached(%q) with %q files: %v", password, ext, err)
+			}
+			if breached != want {
+				t.Errorf("isBreached(%q) with %q files = %v, want %v", password, ext, breached, want)
+			}
+		}
+	}
+}
+
+func TestIsBreachedDisabled(t *testing.T) {
+	setFlag(t, "breachedPasswordsDir", "")
+
+	if breached, err := isBreached("password"); breached || err != nil {
+		t.Errorf("isBreached without a directory = %v, %v", breached, err)
+	}
+}
+
+func TestIsBreachedMalformedCount(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "breachedPasswordsDir", dir)
+	writeRange(t, dir, "bad-count", "many", ".txt")
+
+	if _, err := isBreached("bad-count"); err == nil {
+		t.Error("a malformed count was not reported")
+	}
+}
+
+func TestCheckBreached(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "breachedPasswordsDir", dir)
+	setFlag(t, "breachedPasswordMinCount", "1")
+	writeRange(t, dir, strongPassword, "1", ".txt")
+
+	if rules := violations(t, Check(strongPassword, "user@example.com")); !rules[RuleBreached] || len(rules) != 1 {
+		t.Errorf("violations = %v, want only %s", rules, RuleBreached)
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	setFlag(t, "breachedPasswordsDir", "")
+	setFlag(t, "passwordMinLength", "10")
+	setFlag(t, "passwordMinStrength", "-1")
+
+	for _, tc := range []struct {
+		password string
+		want     string
+	}{
+		{"short", RuleMinLength},
+		{"nine-char", RuleMinLength},
+		// Length counts characters, not bytes.
+		{"ééééééééé", RuleMinLength},
+		{"ten-chars!", ""},
+		{"éééééééééé", ""},
+		{strings.Repeat("a", passwordhash.MaxPasswordLength), ""},
+		{strings.Repeat("a", passwordhash.MaxPasswordLength+1), RuleMaxLength},
+	} {
+		rules := violations(t, Check(tc.password, "user@example.com"))
+		if tc.want == "" && len(rules) > 0 {
+			t.Errorf("%.12q (%d bytes): violations = %v, want none", tc.password, len(tc.password), rules)
+		}
+		if tc.want != "" && (!rules[tc.want] || len(rules) != 1) {
+			t.Errorf("%.12q (%d bytes): violations = %v, want only %s", tc.password, len(tc.password), rules, tc.want)
+		}
+	}
+}
+
+func TestCheckEmailLocalPart(t *testing.T) {
+	setFlag(t, "breachedPasswordsDir", "")
+	setFlag(t, "passwordMinStrength", "-1")
+
+	for _, tc := range []struct {
+		password, email string
+		rejected        bool
+	}{
+		{"xx-Jonathan-2026-xx", "jonathan@example.com", true},
+		{"JONATHAN.SMITH-2026", "Jonathan.Smith@example.com", true},
+		{"violet-harbor-41", "jonathan@example.com", false},
+		// Local-parts shorter than three characters are ignored.
+		{"all-along-the-al-41", "al@example.com", false},
+		{"a-bob-and-a-dog", "bob@example.com", true},
+		{"no-email-given-41", "", false},
+	} {
+		rules := violations(t, Check(tc.password, tc.email))
+		if rules[RuleContainsEmail] != tc.rejected {
+			t.Errorf("Check(%q, %q): violations = %v, want %s = %v", tc.password, tc.email, rules, RuleContainsEmail, tc.rejected)
+		}
+	}
+}
+
+func TestCheckStrength(t *testing.T) {
+	setFlag(t, "breachedPasswordsDir", "")
+	setFlag(t, "passwordMinStrength", "3")
+
+	if rules := violations(t, Check("password1234", "user@example.com")); !rules[RuleStrength] {
+		t.Errorf("weak password: violations = %v, want %s", rules, RuleStrength)
+	}
+	if err := Check(strongPassword, "user@example.com"); err != nil {
+		t.Errorf("strong password: %v", err)
+	}
+
+	// Every failed rule is reported at once.
+	rules := violations(t, Check("user", "user@example.com"))
+	for _, rule := range []string{RuleMinLength, RuleContainsEmail, RuleStrength} {
+		if !rules[rule] {
+			t.Errorf("violations = %v, want %s", rules, rule)
+		}
+	}
+}
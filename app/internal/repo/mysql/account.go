@@ -0,0 +1,168 @@
+package mysql
+
+import (
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// userTables are the tables with rows keyed by user_id that are removed when
+// an account is purged.
+var userTables = []string{
+	"user_ai_settings",
+	"refresh_tokens",
+	"revoked_tokens",
+	"user_token_revocations",
+	"user_totp",
+	"user_recovery_codes",
+	"webauthn_credentials",
+	"one_time_codes",
+	"login_history",
+	"audit_events",
+	"service_access_log",
+}
+
+func (s *Storage) SaveLoginEvent(userID int, method, ip string, success bool) error {
+	query := `INSERT INTO login_history (user_id, method, ip, success, created_at) VALUES (?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := s.db.Exec(query, userID, method, ip, success)
+	if err != nil {
+		logrus.Errorf("Cannot save login event: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) SaveAuditEvent(userID int, event, detail string) error {
+	query := `INSERT INTO audit_events (user_id, event, detail, created_at) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := s.db.Exec(query, userID, event, detail)
+	if err != nil {
+		logrus.Errorf("Cannot save audit event: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) GetLoginHistory(userID int) ([]*models.LoginEvent, error) {
+	query := `SELECT method, ip, success, DATE_FORMAT(created_at, '%Y-%m-%dT%H:%i:%sZ') FROM login_history WHERE user_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		logrus.Errorf("Cannot get login history: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.LoginEvent{}
+	for rows.Next() {
+		var event models.LoginEvent
+		if err := rows.Scan(&event.Method, &event.IP, &event.Success, &event.CreatedAt); err != nil {
+			logrus.Errorf("Cannot scan login event: %v", err)
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+func (s *Storage) GetAuditEvents(userID int) ([]*models.AuditEvent, error) {
+	query := `SELECT event, detail, DATE_FORMAT(created_at, '%Y-%m-%dT%H:%i:%sZ') FROM audit_events WHERE user_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		logrus.Errorf("Cannot get audit events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := rows.Scan(&event.Event, &event.Detail, &event.CreatedAt); err != nil {
+			logrus.Errorf("Cannot scan audit event: %v", err)
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+// SoftDeleteUser hides the account from every lookup and schedules it for
+// purging once the grace period is over.
+func (s *Storage) SoftDeleteUser(userID int, grace time.Duration) error {
+	query := `UPDATE users SET deleted_at = UTC_TIMESTAMP(), purge_after = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND)
+		WHERE id = ? AND deleted_at IS NULL`
+
+	result, err := s.db.Exec(query, int64(grace.Seconds()), userID)
+	if err != nil {
+		logrus.Errorf("Cannot soft delete user: %v", err)
+		return err
+	}
+	return expectAffected(result, fmt.Errorf("user %d not found", userID))
+}
+
+// RestoreUser undoes SoftDeleteUser while the account is not purged yet.
+func (s *Storage) RestoreUser(userID int) error {
+	query := `UPDATE users SET deleted_at = NULL, purge_after = NULL WHERE id = ? AND deleted_at IS NOT NULL`
+
+	result, err := s.db.Exec(query, userID)
+	if err != nil {
+		logrus.Errorf("Cannot restore user: %v", err)
+		return err
+	}
+	return expectAffected(result, fmt.Errorf("user %d is not scheduled for deletion", userID))
+}
+
+// GetUsersToPurge returns the ID and email of accounts past their grace
+// period.
+func (s *Storage) GetUsersToPurge(limit int) ([]*models.UserData, error) {
+	query := `SELECT id, email FROM users WHERE purge_after < UTC_TIMESTAMP() LIMIT ?`
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		logrus.Errorf("Cannot get users to purge: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.UserData
+	for rows.Next() {
+		var user models.UserData
+		if err := rows.Scan(&user.ID, &user.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// PurgeUser removes the user row, every row keyed by the user and the failed
+// login counter of the account, as long as the account is still due for
+// purging.
+func (s *Storage) PurgeUser(userID int, loginFailureKey string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ? AND purge_after < UTC_TIMESTAMP()`, userID)
+	if err != nil {
+		logrus.Errorf("Cannot purge user: %v", err)
+		return err
+	}
+	if err = expectAffected(result, fmt.Errorf("user %d is not due for purging", userID)); err != nil {
+		return err
+	}
+
+	for _, table := range userTables {
+		if _, err = tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			logrus.Errorf("Cannot purge %s of user: %v", table, err)
+			return err
+		}
+	}
+	if _, err = tx.Exec(`DELETE FROM login_failures WHERE name = ?`, loginFailureKey); err != nil {
+		logrus.Errorf("Cannot purge login failures of user: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
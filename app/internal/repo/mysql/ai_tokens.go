@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// GetPlaintextAITokens returns settings rows whose token is not encrypted yet.
+func (s *Storage) GetPlaintextAITokens(limit int) ([]*models.UserSettings, error) {
+	query := `SELECT id, user_id, token, '', '' FROM user_ai_settings
+		WHERE token_key_id IS NULL AND token <> '' ORDER BY id LIMIT ?`
+	return s.getAITokens(query, limit)
+}
+
+// GetAITokensToRewrap returns settings rows whose data key is wrapped with a
+// master key other than activeKeyID.
+func (s *Storage) GetAITokensToRewrap(activeKeyID string, limit int) ([]*models.UserSettings, error) {
+	query := `SELECT id, user_id, token, token_key_id, token_data_key FROM user_ai_settings
+		WHERE token_key_id IS NOT NULL AND token_key_id <> ? ORDER BY id LIMIT ?`
+	return s.getAITokens(query, activeKeyID, limit)
+}
+
+func (s *Storage) getAITokens(query string, args ...interface{}) ([]*models.UserSettings, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		logrus.Errorf("Cannot get AI tokens: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settingsList []*models.UserSettings
+	for rows.Next() {
+		var settings models.UserSettings
+		if err := rows.Scan(&settings.ID, &settings.UserID, &settings.AIToken, &settings.TokenKeyID, &settings.TokenDataKey); err != nil {
+			logrus.Errorf("Cannot scan AI token: %v", err)
+			return nil, err
+		}
+		settingsList = append(settingsList, &settings)
+	}
+	return settingsList, rows.Err()
+}
+
+// ReplaceAIToken stores a re-encrypted token. It reports false when the
+// token was changed since oldToken was read, in which case nothing is written.
+func (s *Storage) ReplaceAIToken(settings models.UserSettings, oldToken string) (bool, error) {
+	query := `UPDATE user_ai_settings SET token = ?, token_key_id = ?, token_data_key = ? WHERE id = ? AND token = ?`
+
+	result, err := s.db.Exec(query, settings.AIToken, settings.TokenKeyID, settings.TokenDataKey, settings.ID, oldToken)
+	if err != nil {
+		logrus.Errorf("Cannot replace AI token: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
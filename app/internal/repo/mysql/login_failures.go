@@ -0,0 +1,90 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// GetLoginFailures returns an empty record when the key has no failures.
+func (s *Storage) GetLoginFailures(name string) (*models.LoginFailures, error) {
+	query := `SELECT failures, last_failure_at, locked_until, lockouts FROM login_failures WHERE name = ?`
+
+	failures := &models.LoginFailures{}
+	err := s.db.QueryRow(query, name).Scan(&failures.Failures, &failures.LastFailureAt, &failures.LockedUntil, &failures.Lockouts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return failures, nil
+	}
+	if err != nil {
+		logrus.Errorf("Cannot get login failures: %v", err)
+		return nil, err
+	}
+	return failures, nil
+}
+
+// AddLoginFailure counts a failure at now. Failures older than windowStart
+// are forgotten and the count starts over; the lockout count is kept.
+func (s *Storage) AddLoginFailure(name string, now, windowStart int64) (*models.LoginFailures, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO login_failures (name, failures, last_failure_at) VALUES (?, 1, ?)
+		ON DUPLICATE KEY UPDATE failures = IF(last_failure_at < ?, 1, failures + 1), last_failure_at = VALUES(last_failure_at)`
+	if _, err = tx.Exec(query, name, now, windowStart); err != nil {
+		logrus.Errorf("Cannot add login failure: %v", err)
+		return nil, err
+	}
+
+	failures := &models.LoginFailures{}
+	query = `SELECT failures, last_failure_at, locked_until, lockouts FROM login_failures WHERE name = ?`
+	if err = tx.QueryRow(query, name).Scan(&failures.Failures, &failures.LastFailureAt, &failures.LockedUntil, &failures.Lockouts); err != nil {
+		logrus.Errorf("Cannot get login failures: %v", err)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// LockLogin locks the key until lockedUntil and counts the lockout.
+func (s *Storage) LockLogin(name string, lockedUntil int64) error {
+	query := `UPDATE login_failures SET locked_until = GREATEST(locked_until, ?), lockouts = lockouts + 1 WHERE name = ?`
+
+	_, err := s.db.Exec(query, lockedUntil, name)
+	if err != nil {
+		logrus.Errorf("Cannot lock login: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) DeleteLoginFailures(name string) error {
+	query := `DELETE FROM login_failures WHERE name = ?`
+
+	_, err := s.db.Exec(query, name)
+	if err != nil {
+		logrus.Errorf("Cannot delete login failures: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteStaleLoginFailures forgets keys that are not locked and had no
+// failure since lastFailureBefore, or since lockedOutBefore for keys that
+// have been locked out before.
+func (s *Storage) DeleteStaleLoginFailures(lastFailureBefore, lockedOutBefore, now int64) error {
+	query := `DELETE FROM login_failures
+		WHERE locked_until < ? AND last_failure_at < IF(lockouts > 0, ?, ?)`
+
+	_, err := s.db.Exec(query, now, lockedOutBefore, lastFailureBefore)
+	if err != nil {
+		logrus.Errorf("Cannot delete stale login failures: %v", err)
+		return err
+	}
+	return nil
+}
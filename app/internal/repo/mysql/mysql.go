@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/Dimoonevs/user-service/app/internal/models"
@@ -21,6 +22,9 @@ var (
 	mysqlConnectionString = flag.String("SQLConnPassword", "user:pass@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4,utf8", "DB connection")
 	storage               *Storage
 	once                  sync.Once
+
+	ErrUserExists = errors.New("user already exist")
+	ErrEmailTaken = errors.New("email is already in use")
 )
 
 func initMySQLConnection() {
@@ -44,8 +48,8 @@ func GetConnection() *Storage {
 }
 
 func (s *Storage) SaveUserData(userData models.UserData) (int, error) {
-	query := `INSERT INTO users (email, password_hash, verification_token, is_verified) VALUES (?, ?, ?, 0)`
-	result, err := s.db.Exec(query, userData.Email, userData.Password, userData.Code)
+	query := `INSERT INTO users (email, password_hash, is_verified) VALUES (?, ?, 0)`
+	result, err := s.db.Exec(query, userData.Email, userData.Password)
 	if err == nil {
 		id, err := result.LastInsertId()
 		if err != nil {
@@ -58,50 +62,57 @@ func (s *Storage) SaveUserData(userData models.UserData) (int, error) {
 		var id int
 		var isVerified bool
 
-		checkQuery := `SELECT id, is_verified FROM users WHERE email = ?`
+		checkQuery := `SELECT id, is_verified FROM users WHERE email = ? AND deleted_at IS NULL`
 		err = s.db.QueryRow(checkQuery, userData.Email).Scan(&id, &isVerified)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The address belongs to a deleted account that is not purged yet.
+			return 0, ErrUserExists
+		}
 		if err != nil {
 			return 0, err
 		}
 
 		if !isVerified {
-			updateQuery := `UPDATE users SET password_hash = ?, verification_token = ? WHERE id = ?`
-			_, err := s.db.Exec(updateQuery, userData.Password, userData.Code, id)
+			updateQuery := `UPDATE users SET password_hash = ? WHERE id = ?`
+			_, err := s.db.Exec(updateQuery, userData.Password, id)
 			if err != nil {
 				return 0, err
 			}
 			return id, nil
 		}
 
-		return 0, fmt.Errorf("user already exist")
+		return 0, ErrUserExists
 	}
 
 	return 0, err
 }
 
 func (s *Storage) GetUserByEmail(email string) (*models.UserData, error) {
-	query := `SELECT id, is_verified, verification_token, password_hash FROM users WHERE email = ?`
+	query := `SELECT id, is_verified, password_hash FROM users WHERE email = ? AND deleted_at IS NULL`
 
 	userData := &models.UserData{
 		Email: email,
 	}
 	row := s.db.QueryRow(query, email)
 
-	if err := row.Scan(&userData.ID, &userData.IsVerify, &userData.Code, &userData.Password); err != nil {
+	if err := row.Scan(&userData.ID, &userData.IsVerify, &userData.Password); err != nil {
 		logrus.Errorf("Cannot get code by email: %v", err)
 		return nil, err
 	}
 	return userData, nil
 }
 
-func (s *Storage) UpdateVerifyCode(userID int, code string) error {
-	query := `UPDATE users SET verification_token = ? WHERE id = ?`
+func (s *Storage) GetUserByID(userID int) (*models.UserData, error) {
+	query := `SELECT id, email, is_verified, password_hash FROM users WHERE id = ? AND deleted_at IS NULL`
 
-	_, err := s.db.Exec(query, code, userID)
-	if err != nil {
-		return err
+	userData := &models.UserData{}
+	row := s.db.QueryRow(query, userID)
+
+	if err := row.Scan(&userData.ID, &userData.Email, &userData.IsVerify, &userData.Password); err != nil {
+		logrus.Errorf("Cannot get user by id: %v", err)
+		return nil, err
 	}
-	return nil
+	return userData, nil
 }
 
 func (s *Storage) VerifyUser(email string) error {
@@ -148,13 +159,35 @@ func (s *Storage) ChangeDataUser(email, password string, userID int) error {
 	args = append(args, userID)
 
 	_, err := s.db.Exec(query, args...)
+	if isDuplicateEntry(err) {
+		return ErrEmailTaken
+	}
 	return err
 }
 
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+// ReplacePasswordHash swaps the hash only if it is still the one that was
+// read, so a password changed in the meantime is not overwritten.
+func (s *Storage) ReplacePasswordHash(userID int, oldHash, newHash string) error {
+	query := `UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?`
+
+	_, err := s.db.Exec(query, newHash, userID, oldHash)
+	if err != nil {
+		logrus.Errorf("Cannot replace password hash: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (s *Storage) SetUserSettings(userID int, settings models.UserSettings) error {
-	query := `INSERT INTO user_ai_settings (user_id, token, gpt_model, whisper_model, tts_model, name) VALUES (?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO user_ai_settings (user_id, token, token_key_id, token_data_key, gpt_model, whisper_model, tts_model, name)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?)`
 
-	_, err := s.db.Exec(query, userID, settings.AIToken, settings.GPTModel, settings.WhisperModel, settings.TTSModel, settings.Name)
+	_, err := s.db.Exec(query, userID, settings.AIToken, settings.TokenKeyID, settings.TokenDataKey, settings.GPTModel, settings.WhisperModel, settings.TTSModel, settings.Name)
 	if err != nil {
 		logrus.Errorf("Cannot set user settings: %v", err)
 		return err
@@ -163,7 +196,8 @@ func (s *Storage) SetUserSettings(userID int, settings models.UserSettings) erro
 }
 
 func (s *Storage) GetUserSettings(userID int) ([]*models.UserSettings, error) {
-	query := `SELECT id, user_id, token, gpt_model, whisper_model, tts_model, name FROM user_ai_settings WHERE user_id = ?`
+	query := `SELECT id, user_id, token, COALESCE(token_key_id, ''), COALESCE(token_data_key, ''), gpt_model, whisper_model, tts_model, name
+		FROM user_ai_settings WHERE user_id = ?`
 	rows, err := s.db.Query(query, userID)
 	if err != nil {
 		logrus.Errorf("Cannot get user settings: %v", err)
@@ -175,7 +209,7 @@ func (s *Storage) GetUserSettings(userID int) ([]*models.UserSettings, error) {
 
 	for rows.Next() {
 		var settings models.UserSettings
-		if err := rows.Scan(&settings.ID, &settings.UserID, &settings.AIToken, &settings.GPTModel, &settings.WhisperModel, &settings.TTSModel, &settings.Name); err != nil {
+		if err := rows.Scan(&settings.ID, &settings.UserID, &settings.AIToken, &settings.TokenKeyID, &settings.TokenDataKey, &settings.GPTModel, &settings.WhisperModel, &settings.TTSModel, &settings.Name); err != nil {
 			logrus.Errorf("Cannot scan user settings: %v", err)
 			return nil, err
 		}
@@ -190,14 +224,27 @@ func (s *Storage) GetUserSettings(userID int) ([]*models.UserSettings, error) {
 	return settingsList, nil
 }
 
+func (s *Storage) GetUserSettingsByID(userID, settingsID int) (*models.UserSettings, error) {
+	query := `SELECT s.id, s.user_id, s.token, COALESCE(s.token_key_id, ''), COALESCE(s.token_data_key, ''), s.gpt_model, s.whisper_model, s.tts_model, s.name
+		FROM user_ai_settings s JOIN users u ON u.id = s.user_id WHERE s.user_id = ? AND s.id = ? AND u.deleted_at IS NULL`
+
+	var settings models.UserSettings
+	row := s.db.QueryRow(query, userID, settingsID)
+	if err := row.Scan(&settings.ID, &settings.UserID, &settings.AIToken, &settings.TokenKeyID, &settings.TokenDataKey, &settings.GPTModel, &settings.WhisperModel, &settings.TTSModel, &settings.Name); err != nil {
+		logrus.Errorf("Cannot get user settings by id: %v", err)
+		return nil, err
+	}
+	return &settings, nil
+}
+
 func (s *Storage) UpdateUserSettings(userID int, settings models.UserSettings) error {
 	query := "UPDATE user_ai_settings SET "
 	args := []interface{}{}
 	updates := []string{}
 
 	if settings.AIToken != "" {
-		updates = append(updates, "token = ?")
-		args = append(args, settings.AIToken)
+		updates = append(updates, "token = ?", "token_key_id = NULLIF(?, '')", "token_data_key = NULLIF(?, '')")
+		args = append(args, settings.AIToken, settings.TokenKeyID, settings.TokenDataKey)
 	}
 	if settings.GPTModel != "" {
 		updates = append(updates, "gpt_model = ?")
@@ -227,8 +274,13 @@ func (s *Storage) UpdateUserSettings(userID int, settings models.UserSettings) e
 	return err
 }
 
-func (s *Storage) SetCodeByEmail(email, code string) error {
-	query := `UPDATE users SET verification_token = ? WHERE email = ?`
-	_, err := s.db.Exec(query, code, email)
-	return err
+func expectAffected(result sql.Result, notFound error) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
 }
@@ -0,0 +1,191 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// IssueOneTimeCode stores a new code for the purpose and invalidates the
+// earlier unused codes of the same user and purpose, so only the latest one
+// works. Codes of other purposes are left alone.
+func (s *Storage) IssueOneTimeCode(userID int, purpose, codeHash, payload string, ttl time.Duration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE one_time_codes SET used_at = UTC_TIMESTAMP() WHERE user_id = ? AND purpose = ? AND used_at IS NULL`
+	if _, err = tx.Exec(query, userID, purpose); err != nil {
+		logrus.Errorf("Cannot invalidate one-time codes: %v", err)
+		return err
+	}
+
+	query = `INSERT INTO one_time_codes (user_id, purpose, code_hash, payload, expires_at, created_at)
+		VALUES (?, ?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND), UTC_TIMESTAMP())`
+	if _, err = tx.Exec(query, userID, purpose, codeHash, payload, int64(ttl.Seconds())); err != nil {
+		logrus.Errorf("Cannot save one-time code: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) CountRecentOneTimeCodes(userID int, purpose string, window time.Duration) (int, error) {
+	query := `SELECT COUNT(*) FROM one_time_codes WHERE user_id = ? AND purpose = ? AND created_at > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+
+	var count int
+	if err := s.db.QueryRow(query, userID, purpose, int64(window.Seconds())).Scan(&count); err != nil {
+		logrus.Errorf("Cannot count one-time codes: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// UseOneTimeCodeAttempt counts one attempt against the active code of the
+// purpose before the caller compares it, and returns that code. It returns
+// nil when there is no active code or maxAttempts were already spent, which
+// burns the code.
+func (s *Storage) UseOneTimeCodeAttempt(userID int, purpose string, maxAttempts int) (*models.OneTimeCode, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE one_time_codes SET attempts = attempts + 1
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > UTC_TIMESTAMP() AND attempts < ?`
+	result, err := tx.Exec(query, userID, purpose, maxAttempts)
+	if err != nil {
+		logrus.Errorf("Cannot use one-time code attempt: %v", err)
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, nil
+	}
+
+	code := &models.OneTimeCode{}
+	query = `SELECT id, code_hash, payload FROM one_time_codes
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL ORDER BY id DESC LIMIT 1`
+	if err = tx.QueryRow(query, userID, purpose).Scan(&code.ID, &code.Hash, &code.Payload); err != nil {
+		logrus.Errorf("Cannot get one-time code: %v", err)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// ConsumeOneTimeCode marks a code returned by UseOneTimeCodeAttempt as used.
+// It reports false when a concurrent request used it first.
+func (s *Storage) ConsumeOneTimeCode(id int) (bool, error) {
+	result, err := s.db.Exec(`UPDATE one_time_codes SET used_at = UTC_TIMESTAMP() WHERE id = ? AND used_at IS NULL`, id)
+	if err != nil {
+		logrus.Errorf("Cannot consume one-time code: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// UseOneTimeToken marks an unguessable token, such as a magic link, as used
+// in one step. It reports false when the token is unknown, bound to another
+// payload, expired or already used.
+func (s *Storage) UseOneTimeToken(userID int, purpose, tokenHash, payload string) (bool, error) {
+	query := `UPDATE one_time_codes SET used_at = UTC_TIMESTAMP()
+		WHERE user_id = ? AND purpose = ? AND code_hash = ? AND payload = ? AND used_at IS NULL AND expires_at > UTC_TIMESTAMP()`
+
+	result, err := s.db.Exec(query, userID, purpose, tokenHash, payload)
+	if err != nil {
+		logrus.Errorf("Cannot use one-time token: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// TakeOneTimeToken is UseOneTimeToken for tokens whose payload is not known
+// to the caller. It returns the payload the token was issued with.
+func (s *Storage) TakeOneTimeToken(userID int, purpose, tokenHash string) (string, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var payload string
+	query := `SELECT id, payload FROM one_time_codes
+		WHERE user_id = ? AND purpose = ? AND code_hash = ? AND used_at IS NULL AND expires_at > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(query, userID, purpose, tokenHash).Scan(&id, &payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		logrus.Errorf("Cannot get one-time token: %v", err)
+		return "", false, err
+	}
+
+	if _, err = tx.Exec(`UPDATE one_time_codes SET used_at = UTC_TIMESTAMP() WHERE id = ?`, id); err != nil {
+		logrus.Errorf("Cannot use one-time token: %v", err)
+		return "", false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", false, err
+	}
+	return payload, true, nil
+}
+
+// RestoreEmailWithToken sets the email to the payload of the token and uses
+// the token up in the same transaction, so a failed swap leaves the token
+// usable. It reports false when the token is unknown, used or expired.
+func (s *Storage) RestoreEmailWithToken(userID int, purpose, tokenHash string) (string, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var email string
+	query := `SELECT id, payload FROM one_time_codes
+		WHERE user_id = ? AND purpose = ? AND code_hash = ? AND used_at IS NULL AND expires_at > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(query, userID, purpose, tokenHash).Scan(&id, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		logrus.Errorf("Cannot get one-time token: %v", err)
+		return "", false, err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET email = ? WHERE id = ? AND deleted_at IS NULL`, email, userID)
+	if isDuplicateEntry(err) {
+		return "", false, ErrEmailTaken
+	}
+	if err != nil {
+		logrus.Errorf("Cannot restore email: %v", err)
+		return "", false, err
+	}
+	if _, err = tx.Exec(`UPDATE one_time_codes SET used_at = UTC_TIMESTAMP() WHERE id = ?`, id); err != nil {
+		logrus.Errorf("Cannot use one-time token: %v", err)
+		return "", false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return "", false, err
+	}
+	return email, true, nil
+}
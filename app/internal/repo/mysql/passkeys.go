@@ -0,0 +1,115 @@
+package mysql
+
+import (
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Storage) SavePasskey(userID int, credentialIDHash string, credential []byte, name string) error {
+	query := `INSERT INTO webauthn_credentials (user_id, credential_id_hash, credential, name) VALUES (?, ?, ?, ?)`
+
+	_, err := s.db.Exec(query, userID, credentialIDHash, credential, name)
+	if err != nil {
+		logrus.Errorf("Cannot save passkey: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetPasskeyCredentials returns the serialized WebAuthn credentials of the user.
+func (s *Storage) GetPasskeyCredentials(userID int) ([][]byte, error) {
+	query := `SELECT credential FROM webauthn_credentials WHERE user_id = ?`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		logrus.Errorf("Cannot get passkey credentials: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials [][]byte
+	for rows.Next() {
+		var credential []byte
+		if err := rows.Scan(&credential); err != nil {
+			logrus.Errorf("Cannot scan passkey credential: %v", err)
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Error iterating passkey credentials: %v", err)
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *Storage) ListPasskeys(userID int) ([]*models.Passkey, error) {
+	query := `SELECT id, name, DATE_FORMAT(created_at, '%Y-%m-%dT%H:%i:%sZ'), COALESCE(DATE_FORMAT(last_used_at, '%Y-%m-%dT%H:%i:%sZ'), '')
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY id`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		logrus.Errorf("Cannot list passkeys: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*models.Passkey{}
+	for rows.Next() {
+		var passkey models.Passkey
+		if err := rows.Scan(&passkey.ID, &passkey.Name, &passkey.CreatedAt, &passkey.LastUsedAt); err != nil {
+			logrus.Errorf("Cannot scan passkey: %v", err)
+			return nil, err
+		}
+		passkeys = append(passkeys, &passkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		logrus.Errorf("Error iterating passkeys: %v", err)
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (s *Storage) RenamePasskey(userID, passkeyID int, name string) error {
+	var exists bool
+	checkQuery := `SELECT EXISTS(SELECT 1 FROM webauthn_credentials WHERE id = ? AND user_id = ?)`
+	if err := s.db.QueryRow(checkQuery, passkeyID, userID).Scan(&exists); err != nil {
+		logrus.Errorf("Cannot check passkey: %v", err)
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("passkey %d not found", passkeyID)
+	}
+
+	query := `UPDATE webauthn_credentials SET name = ? WHERE id = ? AND user_id = ?`
+	if _, err := s.db.Exec(query, name, passkeyID, userID); err != nil {
+		logrus.Errorf("Cannot rename passkey: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) DeletePasskey(userID, passkeyID int) error {
+	query := `DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?`
+
+	result, err := s.db.Exec(query, passkeyID, userID)
+	if err != nil {
+		logrus.Errorf("Cannot delete passkey: %v", err)
+		return err
+	}
+	return expectAffected(result, fmt.Errorf("passkey %d not found", passkeyID))
+}
+
+// UpdatePasskeyUsage stores the credential with its new signature counter
+// after a successful login.
+func (s *Storage) UpdatePasskeyUsage(credentialIDHash string, credential []byte) error {
+	query := `UPDATE webauthn_credentials SET credential = ?, last_used_at = UTC_TIMESTAMP() WHERE credential_id_hash = ?`
+
+	_, err := s.db.Exec(query, credential, credentialIDHash)
+	if err != nil {
+		logrus.Errorf("Cannot update passkey usage: %v", err)
+		return err
+	}
+	return nil
+}
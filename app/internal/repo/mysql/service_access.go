@@ -0,0 +1,18 @@
+package mysql
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Storage) SaveServiceAccess(caller models.ServiceCaller, action string, userID, settingsID int, success bool) error {
+	query := `INSERT INTO service_access_log (client_id, auth_method, action, user_id, settings_id, ip, success, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := s.db.Exec(query, caller.ClientID, caller.AuthMethod, action, userID, settingsID, caller.IP, success)
+	if err != nil {
+		logrus.Errorf("Cannot save service access: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package mysql
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+func (s *Storage) SaveRefreshToken(userID int, familyID, tokenHash string, ttl time.Duration) error {
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES (?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err := s.db.Exec(query, userID, familyID, tokenHash, int64(ttl.Seconds()))
+	if err != nil {
+		logrus.Errorf("Cannot save refresh token: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) GetRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	query := `SELECT rt.id, rt.user_id, u.email, rt.family_id, TIMESTAMPDIFF(SECOND, UTC_TIMESTAMP(), rt.expires_at),
+		rt.expires_at < UTC_TIMESTAMP(), rt.used_at IS NOT NULL, rt.revoked_at IS NOT NULL
+		FROM refresh_tokens rt JOIN users u ON u.id = rt.user_id WHERE rt.token_hash = ? AND u.deleted_at IS NULL`
+
+	token := &models.RefreshToken{}
+	row := s.db.QueryRow(query, tokenHash)
+	if err := row.Scan(&token.ID, &token.UserID, &token.Email, &token.FamilyID, &token.ExpiresIn, &token.IsExpired, &token.IsUsed, &token.IsRevoked); err != nil {
+		logrus.Errorf("Cannot get refresh token: %v", err)
+		return nil, err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken marks the old token as used and stores its replacement in
+// the same family. It reports false when the old token was already used or
+// revoked by a concurrent request, so the caller can treat it as reuse.
+func (s *Storage) RotateRefreshToken(oldID, userID int, familyID, newTokenHash string, ttl time.Duration) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE refresh_tokens SET used_at = UTC_TIMESTAMP() WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL`, oldID)
+	if err != nil {
+		logrus.Errorf("Cannot mark refresh token as used: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES (?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	if _, err = tx.Exec(query, userID, familyID, newTokenHash, int64(ttl.Seconds())); err != nil {
+		logrus.Errorf("Cannot save rotated refresh token: %v", err)
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Storage) RevokeRefreshTokenFamily(familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE family_id = ? AND revoked_at IS NULL`
+
+	_, err := s.db.Exec(query, familyID)
+	if err != nil {
+		logrus.Errorf("Cannot revoke refresh token family: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) RevokeUserRefreshTokens(userID int) error {
+	query := `UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE user_id = ? AND revoked_at IS NULL`
+
+	_, err := s.db.Exec(query, userID)
+	if err != nil {
+		logrus.Errorf("Cannot revoke user refresh tokens: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) RevokeToken(jti string, userID int, expiresAt int64) error {
+	query := `INSERT IGNORE INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?)`
+
+	_, err := s.db.Exec(query, jti, userID, expiresAt)
+	if err != nil {
+		logrus.Errorf("Cannot revoke token: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) RevokeUserTokens(userID int, revokedBeforeMs int64) error {
+	query := `INSERT INTO user_token_revocations (user_id, revoked_before) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE revoked_before = GREATEST(revoked_before, VALUES(revoked_before))`
+
+	_, err := s.db.Exec(query, userID, revokedBeforeMs)
+	if err != nil {
+		logrus.Errorf("Cannot revoke user tokens: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Storage) IsTokenRevoked(jti string, userID int, issuedAtMs int64) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		OR EXISTS(SELECT 1 FROM user_token_revocations WHERE user_id = ? AND revoked_before > ?)`
+
+	var revoked bool
+	if err := s.db.QueryRow(query, jti, userID, issuedAtMs).Scan(&revoked); err != nil {
+		logrus.Errorf("Cannot check token revocation: %v", err)
+		return false, err
+	}
+	return revoked, nil
+}
+
+func (s *Storage) DeleteExpiredRevokedTokens() error {
+	query := `DELETE FROM revoked_tokens WHERE expires_at < UNIX_TIMESTAMP()`
+
+	_, err := s.db.Exec(query)
+	if err != nil {
+		logrus.Errorf("Cannot delete expired revoked tokens: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Storage) SavePendingTOTP(userID int, secret string) error {
+	query := `INSERT INTO user_totp (user_id, secret, is_confirmed, last_used_step) VALUES (?, ?, 0, 0)
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret), is_confirmed = 0, last_used_step = 0`
+
+	_, err := s.db.Exec(query, userID, secret)
+	if err != nil {
+		logrus.Errorf("Cannot save TOTP secret: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetTOTP returns nil without an error when the user has never set up TOTP.
+func (s *Storage) GetTOTP(userID int) (*models.TOTP, error) {
+	query := `SELECT user_id, secret, is_confirmed, last_used_step FROM user_totp WHERE user_id = ?`
+
+	totp := &models.TOTP{}
+	err := s.db.QueryRow(query, userID).Scan(&totp.UserID, &totp.Secret, &totp.IsConfirmed, &totp.LastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Cannot get TOTP: %v", err)
+		return nil, err
+	}
+	return totp, nil
+}
+
+// UseTOTPStep records the time step of an accepted code. It reports false when
+// the same or a later step was already used, which means the code is replayed.
+func (s *Storage) UseTOTPStep(userID int, step int64, confirm bool) (bool, error) {
+	query := `UPDATE user_totp SET last_used_step = ?, is_confirmed = is_confirmed OR ? WHERE user_id = ? AND last_used_step < ?`
+
+	result, err := s.db.Exec(query, step, confirm, userID, step)
+	if err != nil {
+		logrus.Errorf("Cannot update TOTP step: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (s *Storage) DeleteTOTP(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		logrus.Errorf("Cannot delete TOTP: %v", err)
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		logrus.Errorf("Cannot delete recovery codes: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		logrus.Errorf("Cannot delete recovery codes: %v", err)
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err = tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			logrus.Errorf("Cannot save recovery code: %v", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode burns an unused recovery code and reports whether it existed.
+func (s *Storage) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := `UPDATE user_recovery_codes SET used_at = UTC_TIMESTAMP() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+
+	result, err := s.db.Exec(query, userID, codeHash)
+	if err != nil {
+		logrus.Errorf("Cannot use recovery code: %v", err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
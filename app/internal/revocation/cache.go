@@ -0,0 +1,102 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	revoked   bool
+	expiresAt time.Time
+}
+
+// cachedStore answers repeated lookups for the same token from memory.
+// Revocations made through this process are visible immediately; revocations
+// made by other instances become visible once the cached entry expires.
+type cachedStore struct {
+	backend Store
+	ttl     time.Duration
+
+	mu            sync.RWMutex
+	entries       map[string]cacheEntry
+	revokedBefore map[int]time.Time
+}
+
+func newCachedStore(backend Store, ttl time.Duration) *cachedStore {
+	c := &cachedStore{
+		backend:       backend,
+		ttl:           ttl,
+		entries:       make(map[string]cacheEntry),
+		revokedBefore: make(map[int]time.Time),
+	}
+	if ttl > 0 {
+		go c.evictExpired()
+	}
+	return c
+}
+
+func (c *cachedStore) Revoke(jti string, userID int, expiresAt time.Time) error {
+	if err := c.backend.Revoke(jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.entries[jti] = cacheEntry{revoked: true, expiresAt: expiresAt}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *cachedStore) RevokeAll(userID int, before time.Time) error {
+	if err := c.backend.RevokeAll(userID, before); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if before.After(c.revokedBefore[userID]) {
+		c.revokedBefore[userID] = before
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *cachedStore) IsRevoked(jti string, userID int, issuedAt time.Time) (bool, error) {
+	now := time.Now()
+
+	c.mu.RLock()
+	before, userRevoked := c.revokedBefore[userID]
+	entry, cached := c.entries[jti]
+	c.mu.RUnlock()
+
+	if userRevoked && issuedAt.UnixMilli() < before.UnixMilli() {
+		return true, nil
+	}
+	if cached && now.Before(entry.expiresAt) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := c.backend.IsRevoked(jti, userID, issuedAt)
+	if err != nil {
+		return false, err
+	}
+	if c.ttl > 0 && jti != "" {
+		c.mu.Lock()
+		c.entries[jti] = cacheEntry{revoked: revoked, expiresAt: now.Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return revoked, nil
+}
+
+func (c *cachedStore) evictExpired() {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		c.mu.Lock()
+		for jti, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, jti)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
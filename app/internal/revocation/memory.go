@@ -0,0 +1,64 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryStore keeps revocations in process memory. It suits a single instance
+// and tests; revocations are lost on restart and are not shared.
+type memoryStore struct {
+	mu            sync.Mutex
+	revoked       map[string]time.Time
+	revokedBefore map[int]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{
+		revoked:       make(map[string]time.Time),
+		revokedBefore: make(map[int]time.Time),
+	}
+	go s.purgeExpired()
+	return s
+}
+
+func (s *memoryStore) Revoke(jti string, _ int, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *memoryStore) RevokeAll(userID int, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if before.After(s.revokedBefore[userID]) {
+		s.revokedBefore[userID] = before
+	}
+	return nil
+}
+
+func (s *memoryStore) IsRevoked(jti string, userID int, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if before, ok := s.revokedBefore[userID]; ok && issuedAt.UnixMilli() < before.UnixMilli() {
+		return true, nil
+	}
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *memoryStore) purgeExpired() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for jti, expiresAt := range s.revoked {
+			if now.After(expiresAt) {
+				delete(s.revoked, jti)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
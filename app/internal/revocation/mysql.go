@@ -0,0 +1,41 @@
+package revocation
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"time"
+)
+
+const purgeInterval = time.Hour
+
+type mysqlStore struct {
+	storage *mysql.Storage
+}
+
+func newMySQLStore() *mysqlStore {
+	s := &mysqlStore{
+		storage: mysql.GetConnection(),
+	}
+	go s.purgeExpired()
+	return s
+}
+
+func (s *mysqlStore) Revoke(jti string, userID int, expiresAt time.Time) error {
+	return s.storage.RevokeToken(jti, userID, expiresAt.Unix())
+}
+
+func (s *mysqlStore) RevokeAll(userID int, before time.Time) error {
+	return s.storage.RevokeUserTokens(userID, before.UnixMilli())
+}
+
+func (s *mysqlStore) IsRevoked(jti string, userID int, issuedAt time.Time) (bool, error) {
+	return s.storage.IsTokenRevoked(jti, userID, issuedAt.UnixMilli())
+}
+
+func (s *mysqlStore) purgeExpired() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = s.storage.DeleteExpiredRevokedTokens()
+	}
+}
@@ -0,0 +1,48 @@
+package revocation
+
+import (
+	"flag"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Store keeps track of access tokens that must no longer be accepted even
+// though their signature and expiry are still valid.
+type Store interface {
+	// Revoke rejects a single token by its jti until it expires.
+	Revoke(jti string, userID int, expiresAt time.Time) error
+	// RevokeAll rejects every token of the user issued before the given time.
+	// Times are compared to the millisecond.
+	RevokeAll(userID int, before time.Time) error
+	IsRevoked(jti string, userID int, issuedAt time.Time) (bool, error)
+}
+
+var (
+	revocationBackend  = flag.String("revocationStore", "mysql", "Token revocation store backend: mysql|memory")
+	revocationCacheTTL = flag.Duration("revocationCacheTTL", 30*time.Second, "How long token revocation lookups are cached in process")
+	store              Store
+	once               sync.Once
+)
+
+func initStore() {
+	var backend Store
+	switch *revocationBackend {
+	case "mysql":
+		backend = newMySQLStore()
+	case "memory":
+		backend = newMemoryStore()
+	default:
+		logrus.Fatalf("Unknown revocation store: %s", *revocationBackend)
+	}
+
+	store = newCachedStore(backend, *revocationCacheTTL)
+}
+
+func GetStore() Store {
+	once.Do(func() {
+		initStore()
+	})
+
+	return store
+}
@@ -0,0 +1,40 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevokeAllCutOffHasMillisecondPrecision(t *testing.T) {
+	for name, s := range map[string]Store{
+		"memory": newMemoryStore(),
+		"cached": newCachedStore(newMemoryStore(), time.Minute),
+	} {
+		cutOff := time.Date(2026, 10, 18, 12, 0, 0, int(500*time.Millisecond), time.UTC)
+		if err := s.RevokeAll(1, cutOff); err != nil {
+			t.Fatalf("%s: RevokeAll: %v", name, err)
+		}
+
+		for _, tc := range []struct {
+			issuedAt time.Time
+			revoked  bool
+		}{
+			{cutOff.Add(-time.Millisecond), true},
+			{cutOff.Truncate(time.Second), true},
+			{cutOff, false},
+			{cutOff.Add(time.Millisecond), false},
+		} {
+			revoked, err := s.IsRevoked("", 1, tc.issuedAt)
+			if err != nil {
+				t.Fatalf("%s: IsRevoked: %v", name, err)
+			}
+			if revoked != tc.revoked {
+				t.Errorf("%s: token issued at %s: revoked = %v, want %v", name, tc.issuedAt.Format(time.StampMilli), revoked, tc.revoked)
+			}
+		}
+
+		if revoked, _ := s.IsRevoked("", 2, cutOff.Add(-time.Hour)); revoked {
+			t.Errorf("%s: another user's token was revoked", name)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	loginMethodPassword  = "password"
+	loginMethodMFA       = "mfa"
+	loginMethodPasskey   = "passkey"
+	loginMethodLoginLink = "login_link"
+
+	auditPasswordChanged          = "password_changed"
+	auditPasswordReset            = "password_reset"
+	auditEmailChanged             = "email_changed"
+	auditEmailReverted            = "email_reverted"
+	auditAccountSecured           = "account_secured"
+	auditTOTPEnabled              = "totp_enabled"
+	auditTOTPDisabled             = "totp_disabled"
+	auditPasskeyRegistered        = "passkey_registered"
+	auditPasskeyDeleted           = "passkey_deleted"
+	auditPasskeyCloneWarning      = "passkey_clone_warning"
+	auditAccountDeletionScheduled = "account_deletion_scheduled"
+	auditAccountRestored          = "account_restored"
+	auditDataExported             = "data_exported"
+	auditAITokenRevealed          = "ai_token_revealed"
+
+	purgeBatchSize = 100
+)
+
+var (
+	accountDeletionGracePeriod = flag.Duration("accountDeletionGracePeriod", 30*24*time.Hour, "How long a deleted account can be restored before it is purged")
+	accountPurgeInterval       = flag.Duration("accountPurgeInterval", time.Hour, "How often accounts past their grace period are purged")
+	accountRestoreURL          = flag.String("accountRestoreURL", "http://localhost:8080/users/account/restore", "Page the account restore link points to, the token is appended as the token query parameter")
+
+	ErrInvalidRestoreLink = errors.New("link is invalid, expired or already used")
+)
+
+// accountStore is the part of the storage behind deleting, restoring,
+// purging and exporting accounts.
+type accountStore interface {
+	GetUserByID(userID int) (*models.UserData, error)
+	GetTOTP(userID int) (*models.TOTP, error)
+	GetUserSettings(userID int) ([]*models.UserSettings, error)
+	ListPasskeys(userID int) ([]*models.Passkey, error)
+	SoftDeleteUser(userID int, grace time.Duration) error
+	RestoreUser(userID int) error
+	GetUsersToPurge(limit int) ([]*models.UserData, error)
+	PurgeUser(userID int, loginFailureKey string) error
+	IssueOneTimeCode(userID int, purpose, codeHash, payload string, ttl time.Duration) error
+	TakeOneTimeToken(userID int, purpose, tokenHash string) (string, bool, error)
+	SaveAuditEvent(userID int, event, detail string) error
+	GetAuditEvents(userID int) ([]*models.AuditEvent, error)
+	GetLoginHistory(userID int) ([]*models.LoginEvent, error)
+}
+
+// accountStorage returns the account store; tests replace it.
+var accountStorage = func() accountStore {
+	return mysql.GetConnection()
+}
+
+// recordLogin adds an entry to the login history. Errors are only logged, the
+// history must never fail a login.
+func recordLogin(userID int, method, ip string, success bool) {
+	if err := mysql.GetConnection().SaveLoginEvent(userID, method, ip, success); err != nil {
+		logrus.Errorf("Failed to record login: %v", err)
+	}
+}
+
+// recordAudit adds a security relevant change to the audit trail of the
+// user. Errors are only logged, the change itself is already made.
+func recordAudit(userID int, event, detail string) {
+	if err := accountStorage().SaveAuditEvent(userID, event, detail); err != nil {
+		logrus.Errorf("Failed to record audit event %s: %v", event, err)
+	}
+}
+
+// DeleteAccount schedules the account for purging after re-checking the
+// password and, with 2FA on, the second factor. The account disappears at
+// once, every session is signed out and the owner gets a link to restore it
+// during the grace period.
+func DeleteAccount(userID int, ip string, req models.MFAReq) error {
+	userData, err := accountStorage().GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if err = loginguard.Check(userData.Email, ip); err != nil {
+		return err
+	}
+	if err = verifyPassword(userData.Password, req.Password); err != nil {
+		recordLoginFailure(userData.Email, ip, true)
+		return err
+	}
+	mfaEnabled, err := isTOTPEnabled(userID)
+	if err != nil {
+		return err
+	}
+	if mfaEnabled {
+		if err = verifySecondFactor(userID, req.Code, req.RecoveryCode); err != nil {
+			return err
+		}
+	}
+
+	if err = accountStorage().SoftDeleteUser(userID, *accountDeletionGracePeriod); err != nil {
+		return err
+	}
+	recordAudit(userID, auditAccountDeletionScheduled, "")
+	if err = LogoutAll(userID); err != nil {
+		logrus.Errorf("Failed to revoke tokens after account deletion: %v", err)
+		return err
+	}
+
+	link, err := accountLink(*accountRestoreURL, userID, purposeRestoreAccount, "", *accountDeletionGracePeriod)
+	if err != nil {
+		logrus.Errorf("Failed to create account restore link: %v", err)
+		return nil
+	}
+	if err = sendAccountDeletedEmail(userData.Email, link); err != nil {
+		logrus.Errorf("Failed to send account deleted email: %v", err)
+	}
+	return nil
+}
+
+// RestoreAccount undoes DeleteAccount from the link sent to the owner. The
+// user signs in again afterwards.
+func RestoreAccount(token string) error {
+	userID, _, err := useAccountLink(token, purposeRestoreAccount, ErrInvalidRestoreLink)
+	if err != nil {
+		return err
+	}
+	if err = accountStorage().RestoreUser(userID); err != nil {
+		return ErrInvalidRestoreLink
+	}
+	recordAudit(userID, auditAccountRestored, "")
+	return nil
+}
+
+// ExportAccount collects the data stored about the user for a data export.
+func ExportAccount(userID int) (*models.AccountExport, error) {
+	userData, err := accountStorage().GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	mfaEnabled, err := isTOTPEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := GetMaskedUserSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := accountStorage().ListPasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	logins, err := accountStorage().GetLoginHistory(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The export itself is part of the trail it returns.
+	recordAudit(userID, auditDataExported, "")
+	events, err := accountStorage().GetAuditEvents(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AccountExport{
+		Profile: models.Profile{
+			ID:               userData.ID,
+			Email:            userData.Email,
+			IsVerified:       userData.IsVerify,
+			TwoFactorEnabled: mfaEnabled,
+		},
+		Settings:     settings,
+		Passkeys:     passkeys,
+		LoginHistory: logins,
+		AuditEvents:  events,
+	}, nil
+}
+
+// PurgeDeletedAccounts removes accounts whose grace period is over, together
+// with their AI settings and every other row keyed by the user. It runs until
+// the process exits.
+func PurgeDeletedAccounts() {
+	ticker := time.NewTicker(*accountPurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purgeDeletedAccounts()
+	}
+}
+
+func purgeDeletedAccounts() {
+	for {
+		users, err := accountStorage().GetUsersToPurge(purgeBatchSize)
+		if err != nil {
+			return
+		}
+		// A failing user is retried on the next tick instead of being fetched
+		// again in this run.
+		failed := false
+		for _, user := range users {
+			if err = accountStorage().PurgeUser(user.ID, loginguard.AccountKey(user.Email)); err != nil {
+				logrus.Errorf("Failed to purge user %d: %v", user.ID, err)
+				failed = true
+				continue
+			}
+			logrus.Infof("Purged deleted user %d", user.ID)
+		}
+		if failed || len(users) < purgeBatchSize {
+			return
+		}
+	}
+}
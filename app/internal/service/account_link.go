@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountLink issues a single-use token for the purpose and returns baseURL
+// with the token as the token query parameter. The token carries the user ID
+// in front of the secret part, and only the hash of the secret is stored.
+func accountLink(baseURL string, userID int, purpose, payload string, ttl time.Duration) (string, error) {
+	secret, err := lib.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err = accountStorage().IssueOneTimeCode(userID, purpose, lib.HashToken(secret), payload, ttl); err != nil {
+		return "", err
+	}
+
+	link, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", fmt.Sprintf("%d.%s", userID, secret))
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// useAccountLink uses up a token made by accountLink and returns the user ID
+// and the payload it was issued with.
+func useAccountLink(token, purpose string, invalid error) (int, string, error) {
+	userID, secretHash, err := parseAccountLink(token, invalid)
+	if err != nil {
+		return 0, "", err
+	}
+
+	payload, used, err := accountStorage().TakeOneTimeToken(userID, purpose, secretHash)
+	if err != nil {
+		return 0, "", err
+	}
+	if !used {
+		return 0, "", invalid
+	}
+	return userID, payload, nil
+}
+
+// parseAccountLink splits a token made by accountLink into the user ID and
+// the hash of the secret part, as stored.
+func parseAccountLink(token string, invalid error) (int, string, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", invalid
+	}
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, "", invalid
+	}
+	return userID, lib.HashToken(secret), nil
+}
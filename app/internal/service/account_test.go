@@ -0,0 +1,359 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/passwordhash"
+	"net/smtp"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryAccounts keeps users, one-time codes and the audit trail the way the
+// MySQL tables do, for tests that do not run against MySQL.
+type memoryAccounts struct {
+	mu       sync.Mutex
+	users    map[int]*memoryUser
+	codes    map[string]string
+	audit    map[int][]string
+	settings map[int][]*models.UserSettings
+	// purgedLoginFailures lists the login failure keys purged with a user.
+	purgedLoginFailures []string
+}
+
+type memoryUser struct {
+	models.UserData
+	deleted    bool
+	purgeAfter time.Time
+}
+
+func useMemoryAccounts(t *testing.T) *memoryAccounts {
+	t.Helper()
+
+	store := &memoryAccounts{
+		users:    make(map[int]*memoryUser),
+		codes:    make(map[string]string),
+		audit:    make(map[int][]string),
+		settings: make(map[int][]*models.UserSettings),
+	}
+	previous := accountStorage
+	accountStorage = func() accountStore { return store }
+	t.Cleanup(func() { accountStorage = previous })
+	return store
+}
+
+func (s *memoryAccounts) addUser(t *testing.T, id int, email, password string) {
+	t.Helper()
+
+	hash, err := passwordhash.Hash(password)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[id] = &memoryUser{UserData: models.UserData{ID: id, Email: email, IsVerify: true, Password: hash}}
+}
+
+func (s *memoryAccounts) events(userID int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.audit[userID]...)
+}
+
+func (s *memoryAccounts) GetUserByID(userID int) (*models.UserData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.deleted {
+		return nil, sql.ErrNoRows
+	}
+	userData := user.UserData
+	return &userData, nil
+}
+
+func (s *memoryAccounts) GetTOTP(userID int) (*models.TOTP, error) {
+	return nil, nil
+}
+
+func (s *memoryAccounts) GetUserSettings(userID int) ([]*models.UserSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var settings []*models.UserSettings
+	for _, stored := range s.settings[userID] {
+		copied := *stored
+		settings = append(settings, &copied)
+	}
+	return settings, nil
+}
+
+func (s *memoryAccounts) ListPasskeys(userID int) ([]*models.Passkey, error) {
+	return []*models.Passkey{}, nil
+}
+
+func (s *memoryAccounts) SoftDeleteUser(userID int, grace time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || user.deleted {
+		return sql.ErrNoRows
+	}
+	user.deleted = true
+	user.purgeAfter = time.Now().Add(grace)
+	return nil
+}
+
+func (s *memoryAccounts) RestoreUser(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || !user.deleted {
+		return sql.ErrNoRows
+	}
+	user.deleted = false
+	user.purgeAfter = time.Time{}
+	return nil
+}
+
+func (s *memoryAccounts) GetUsersToPurge(limit int) ([]*models.UserData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []*models.UserData
+	for _, user := range s.users {
+		if user.deleted && user.purgeAfter.Before(time.Now()) && len(users) < limit {
+			userData := user.UserData
+			users = append(users, &userData)
+		}
+	}
+	return users, nil
+}
+
+func (s *memoryAccounts) PurgeUser(userID int, loginFailureKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || !user.deleted || !user.purgeAfter.Before(time.Now()) {
+		return sql.ErrNoRows
+	}
+	delete(s.users, userID)
+	delete(s.audit, userID)
+	delete(s.settings, userID)
+	s.purgedLoginFailures = append(s.purgedLoginFailures, loginFailureKey)
+	return nil
+}
+
+func (s *memoryAccounts) IssueOneTimeCode(userID int, purpose, codeHash, payload string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[codeKey(userID, purpose, codeHash)] = payload
+	return nil
+}
+
+func (s *memoryAccounts) TakeOneTimeToken(userID int, purpose, tokenHash string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := codeKey(userID, purpose, tokenHash)
+	payload, ok := s.codes[key]
+	delete(s.codes, key)
+	return payload, ok, nil
+}
+
+func codeKey(userID int, purpose, hash string) string {
+	return fmt.Sprintf("%d/%s/%s", userID, purpose, hash)
+}
+
+func (s *memoryAccounts) SaveAuditEvent(userID int, event, detail string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit[userID] = append(s.audit[userID], event)
+	return nil
+}
+
+func (s *memoryAccounts) GetAuditEvents(userID int) ([]*models.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []*models.AuditEvent{}
+	for _, event := range s.audit[userID] {
+		events = append(events, &models.AuditEvent{Event: event})
+	}
+	return events, nil
+}
+
+func (s *memoryAccounts) GetLoginHistory(userID int) ([]*models.LoginEvent, error) {
+	return []*models.LoginEvent{}, nil
+}
+
+// captureEmails records the body of every email sent during the test.
+func captureEmails(t *testing.T) *[]string {
+	t.Helper()
+
+	var mu sync.Mutex
+	var sent []string
+	previous := sendMail
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, string(msg))
+		return nil
+	}
+	t.Cleanup(func() { sendMail = previous })
+	return &sent
+}
+
+var linkToken = regexp.MustCompile(`token=(\S+)`)
+
+func restoreToken(t *testing.T, email string) string {
+	t.Helper()
+
+	match := linkToken.FindStringSubmatch(email)
+	if match == nil {
+		t.Fatalf("no restore link in email:\n%s", email)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("unescape token: %v", err)
+	}
+	return token
+}
+
+func TestDeleteAccountNeedsPassword(t *testing.T) {
+	accounts := useMemoryAccounts(t)
+	useMemoryRefreshTokens(t)
+	emails := captureEmails(t)
+	accounts.addUser(t, 7, "wrong-password@example.com", "correct horse battery")
+
+	if err := DeleteAccount(7, "", models.MFAReq{Password: "wrong password"}); err == nil {
+		t.Fatal("account deleted with a wrong password")
+	}
+	if _, err := accounts.GetUserByID(7); err != nil {
+		t.Errorf("account gone after a wrong password: %v", err)
+	}
+	if len(*emails) != 0 {
+		t.Errorf("%d emails sent after a wrong password", len(*emails))
+	}
+}
+
+func TestDeleteAndRestoreAccount(t *testing.T) {
+	accounts := useMemoryAccounts(t)
+	tokens := useMemoryRefreshTokens(t)
+	emails := captureEmails(t)
+	accounts.addUser(t, 42, "user@example.com", "correct horse battery")
+	pair := mustIssue(t)
+
+	if err := DeleteAccount(42, "", models.MFAReq{Password: "correct horse battery"}); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if _, err := accounts.GetUserByID(42); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted account still found: %v", err)
+	}
+	if stored, _ := tokens.GetRefreshToken(lib.HashToken(pair.RefreshToken)); !stored.IsRevoked {
+		t.Error("refresh token not revoked by the deletion")
+	}
+	if len(*emails) != 1 {
+		t.Fatalf("%d emails sent, want the restore link", len(*emails))
+	}
+	token := restoreToken(t, (*emails)[0])
+
+	if err := RestoreAccount(token); err != nil {
+		t.Fatalf("RestoreAccount: %v", err)
+	}
+	if _, err := accounts.GetUserByID(42); err != nil {
+		t.Errorf("restored account not found: %v", err)
+	}
+	if err := RestoreAccount(token); !errors.Is(err, ErrInvalidRestoreLink) {
+		t.Errorf("second restore: err = %v, want ErrInvalidRestoreLink", err)
+	}
+
+	want := []string{auditAccountDeletionScheduled, auditAccountRestored}
+	if got := accounts.events(42); !equalStrings(got, want) {
+		t.Errorf("audit events = %v, want %v", got, want)
+	}
+}
+
+func TestRestoreAccountRejectsForeignToken(t *testing.T) {
+	useMemoryAccounts(t)
+
+	for _, token := range []string{"", "no-dot", "x.secret", "42.unknown-secret"} {
+		if err := RestoreAccount(token); !errors.Is(err, ErrInvalidRestoreLink) {
+			t.Errorf("token %q: err = %v, want ErrInvalidRestoreLink", token, err)
+		}
+	}
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	accounts := useMemoryAccounts(t)
+	accounts.addUser(t, 1, " Due@Example.com", "password one")
+	accounts.addUser(t, 2, "grace@example.com", "password two")
+	accounts.addUser(t, 3, "active@example.com", "password three")
+	accounts.users[1].deleted = true
+	accounts.users[1].purgeAfter = time.Now().Add(-time.Minute)
+	accounts.users[2].deleted = true
+	accounts.users[2].purgeAfter = time.Now().Add(time.Hour)
+
+	purgeDeletedAccounts()
+
+	if _, ok := accounts.users[1]; ok {
+		t.Error("account past its grace period was not purged")
+	}
+	if _, ok := accounts.users[2]; !ok {
+		t.Error("account in its grace period was purged")
+	}
+	if _, ok := accounts.users[3]; !ok {
+		t.Error("active account was purged")
+	}
+	want := []string{"account:due@example.com"}
+	if !equalStrings(accounts.purgedLoginFailures, want) {
+		t.Errorf("purged login failures = %v, want %v", accounts.purgedLoginFailures, want)
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	accounts := useMemoryAccounts(t)
+	accounts.addUser(t, 5, "export@example.com", "correct horse battery")
+	accounts.settings[5] = []*models.UserSettings{{ID: 9, UserID: 5, AIToken: "sk-plaintext-token-1234", GPTModel: "gpt-4o"}}
+
+	export, err := ExportAccount(5)
+	if err != nil {
+		t.Fatalf("ExportAccount: %v", err)
+	}
+	if export.Profile.ID != 5 || export.Profile.Email != "export@example.com" || !export.Profile.IsVerified {
+		t.Errorf("profile = %+v", export.Profile)
+	}
+	if len(export.Settings) != 1 || export.Settings[0].AIToken == "sk-plaintext-token-1234" {
+		t.Errorf("AI token not masked in the export: %+v", export.Settings)
+	}
+	if export.Settings[0].TokenFingerprint == "" {
+		t.Error("masked AI token has no fingerprint")
+	}
+	if accounts.settings[5][0].AIToken != "sk-plaintext-token-1234" {
+		t.Error("export masked the stored token")
+	}
+	if len(export.AuditEvents) != 1 || export.AuditEvents[0].Event != auditDataExported {
+		t.Errorf("audit events = %+v, want the export itself", export.AuditEvents)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/ai"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+)
+
+var ErrNoAIToken = errors.New("no settings with an AI token to refresh the catalog with")
+
+func aiSettings(settings models.UserSettings) ai.Settings {
+	return ai.Settings{
+		Token:        settings.AIToken,
+		GPTModel:     settings.GPTModel,
+		WhisperModel: settings.WhisperModel,
+		TTSModel:     settings.TTSModel,
+	}
+}
+
+// validateAISettings checks the models of new settings against the catalog,
+// then the token and models with the AI provider unless checks are disabled.
+func validateAISettings(settings models.UserSettings) error {
+	if err := ai.CheckCatalog(aiSettings(settings)); err != nil {
+		return err
+	}
+	return checkWithAIProvider(settings)
+}
+
+// validateAISettingsUpdate checks the models being changed against the
+// catalog, then validates the settings as they will be after the partial
+// update with the provider, so a new token is checked against the stored
+// models and new models against the stored token. The masked form of the
+// stored token, sent back by a form that only showed it, is cleared from the
+// update so the stored token is kept.
+func validateAISettingsUpdate(userID int, update *models.UserSettings) error {
+	if update.AIToken == "" && update.GPTModel == "" && update.WhisperModel == "" && update.TTSModel == "" {
+		return nil
+	}
+	if err := ai.CheckCatalog(aiSettings(*update)); err != nil {
+		return err
+	}
+
+	current, err := mysql.GetConnection().GetUserSettingsByID(userID, update.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSettingsNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err = openAIToken(current); err != nil {
+		return err
+	}
+	if current.AIToken != "" && update.AIToken == lib.MaskToken(current.AIToken) {
+		update.AIToken = ""
+		if update.GPTModel == "" && update.WhisperModel == "" && update.TTSModel == "" {
+			return nil
+		}
+	}
+
+	return checkWithAIProvider(mergeAISettings(*current, *update))
+}
+
+// mergeAISettings returns the stored settings with the fields the partial
+// update sets.
+func mergeAISettings(current, update models.UserSettings) models.UserSettings {
+	if update.AIToken != "" {
+		current.AIToken = update.AIToken
+	}
+	if update.GPTModel != "" {
+		current.GPTModel = update.GPTModel
+	}
+	if update.WhisperModel != "" {
+		current.WhisperModel = update.WhisperModel
+	}
+	if update.TTSModel != "" {
+		current.TTSModel = update.TTSModel
+	}
+	return current
+}
+
+func checkWithAIProvider(settings models.UserSettings) error {
+	provider, err := ai.GetProvider()
+	if err != nil || provider == nil {
+		return err
+	}
+	return ai.ValidateSettings(context.Background(), provider, aiSettings(settings))
+}
+
+// GetModelCatalog returns the models users can pick. With refresh the
+// catalog is reduced to the models the provider lists for the token of the
+// settings entry, or of the first entry with a token when settingsID is 0.
+func GetModelCatalog(userID int, refresh bool, settingsID int) (*ai.Catalog, error) {
+	catalog, err := ai.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	if catalog == nil {
+		catalog = &ai.Catalog{Chat: []ai.Model{}, Transcription: []ai.Model{}, TTS: []ai.Model{}}
+	}
+	if !refresh {
+		return catalog, nil
+	}
+	provider, err := ai.GetProvider()
+	if err != nil || provider == nil {
+		return catalog, err
+	}
+
+	token, err := catalogToken(userID, settingsID)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := provider.ListModels(context.Background(), token)
+	if errors.Is(err, ai.ErrInvalidToken) {
+		return nil, &ai.Error{Fields: []ai.FieldError{{Field: ai.FieldToken, Message: err.Error()}}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return catalog.Available(ids), nil
+}
+
+func catalogToken(userID, settingsID int) (string, error) {
+	if settingsID != 0 {
+		settings, err := mysql.GetConnection().GetUserSettingsByID(userID, settingsID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrSettingsNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		if err = openAIToken(settings); err != nil {
+			return "", err
+		}
+		if settings.AIToken == "" {
+			return "", ErrNoAIToken
+		}
+		return settings.AIToken, nil
+	}
+
+	settingsList, err := GetUserSettings(userID)
+	if err != nil {
+		return "", err
+	}
+	for _, settings := range settingsList {
+		if settings.AIToken != "" {
+			return settings.AIToken, nil
+		}
+	}
+	return "", ErrNoAIToken
+}
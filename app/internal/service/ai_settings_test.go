@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/ai"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMergeAISettings(t *testing.T) {
+	current := models.UserSettings{
+		ID:           3,
+		AIToken:      "sk-stored-token",
+		GPTModel:     "gpt-4o",
+		WhisperModel: "whisper-1",
+		TTSModel:     "tts-1",
+	}
+
+	merged := mergeAISettings(current, models.UserSettings{ID: 3, GPTModel: "gpt-4o-mini"})
+	want := current
+	want.GPTModel = "gpt-4o-mini"
+	if merged != want {
+		t.Errorf("model update: merged = %+v, want %+v", merged, want)
+	}
+
+	merged = mergeAISettings(current, models.UserSettings{ID: 3, AIToken: "sk-new-token"})
+	want = current
+	want.AIToken = "sk-new-token"
+	if merged != want {
+		t.Errorf("token update: merged = %+v, want %+v", merged, want)
+	}
+}
+
+// A new token is checked against the stored models, and new models against
+// the stored token.
+func TestMergedSettingsAreValidatedTogether(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer sk-stored-token":
+			_, _ = w.Write([]byte(`{"data":[{"id":"gpt-4o"},{"id":"whisper-1"}]}`))
+		case "Bearer sk-new-token":
+			_, _ = w.Write([]byte(`{"data":[{"id":"gpt-4o-mini"}]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(server.Close)
+	provider := ai.NewOpenAIProvider(server.URL, server.Client())
+
+	current := models.UserSettings{AIToken: "sk-stored-token", GPTModel: "gpt-4o", WhisperModel: "whisper-1"}
+	validate := func(update models.UserSettings) error {
+		return ai.ValidateSettings(context.Background(), provider, aiSettings(mergeAISettings(current, update)))
+	}
+
+	if err := validate(models.UserSettings{WhisperModel: "whisper-1"}); err != nil {
+		t.Errorf("model available to the stored token: %v", err)
+	}
+
+	var settingsErr *ai.Error
+	if err := validate(models.UserSettings{AIToken: "sk-new-token"}); !errors.As(err, &settingsErr) {
+		t.Errorf("new token without the stored models: err = %v, want *ai.Error", err)
+	} else if len(settingsErr.Fields) != 2 || settingsErr.Fields[0].Field != ai.FieldGPTModel || settingsErr.Fields[1].Field != ai.FieldWhisperModel {
+		t.Errorf("new token without the stored models: fields = %v", settingsErr.Fields)
+	}
+
+	if err := validate(models.UserSettings{GPTModel: "gpt-4o-mini"}); !errors.As(err, &settingsErr) {
+		t.Errorf("model not available to the stored token: err = %v, want *ai.Error", err)
+	} else if len(settingsErr.Fields) != 1 || settingsErr.Fields[0].Field != ai.FieldGPTModel {
+		t.Errorf("model not available to the stored token: fields = %v", settingsErr.Fields)
+	}
+}
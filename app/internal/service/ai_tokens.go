@@ -0,0 +1,171 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/Dimoonevs/user-service/app/pkg/envelope"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const aiTokenBatchSize = 100
+
+var (
+	aiTokenReencryptInterval = flag.Duration("aiTokenReencryptInterval", time.Hour, "How often AI tokens wrapped with a retired master key are re-wrapped with the active one")
+
+	ErrSettingsNotFound     = errors.New("settings not found")
+	ErrAITokenKeysNotLoaded = errors.New("aiTokenKeyFile is required to encrypt AI tokens")
+)
+
+// aiTokenAssociatedData binds an encrypted token to its owner, so a token
+// copied into another user's row does not decrypt.
+func aiTokenAssociatedData(userID int) string {
+	return fmt.Sprintf("user_ai_settings:%d", userID)
+}
+
+// sealAIToken replaces the plaintext token of settings with its encrypted
+// form. The token is stored as it is only when the service was started with
+// -allowPlaintextAITokens and no master keys.
+func sealAIToken(userID int, settings *models.UserSettings) error {
+	if settings.AIToken == "" || !envelope.Enabled() {
+		return nil
+	}
+	sealed, err := envelope.Encrypt(settings.AIToken, aiTokenAssociatedData(userID))
+	if err != nil {
+		logrus.Errorf("Cannot encrypt AI token: %v", err)
+		return err
+	}
+	settings.AIToken = sealed.Ciphertext
+	settings.TokenKeyID = sealed.KeyID
+	settings.TokenDataKey = sealed.DataKey
+	return nil
+}
+
+// openAIToken replaces the stored token of settings with the plaintext.
+// Tokens that were not encrypted yet are returned as they are.
+func openAIToken(settings *models.UserSettings) error {
+	if settings.TokenKeyID == "" {
+		return nil
+	}
+	token, err := envelope.Decrypt(aiTokenSealed(settings), aiTokenAssociatedData(settings.UserID))
+	if err != nil {
+		logrus.Errorf("Cannot decrypt AI token of settings %d: %v", settings.ID, err)
+		return err
+	}
+	settings.AIToken = token
+	settings.TokenKeyID = ""
+	settings.TokenDataKey = ""
+	return nil
+}
+
+func aiTokenSealed(settings *models.UserSettings) envelope.Sealed {
+	return envelope.Sealed{
+		KeyID:      settings.TokenKeyID,
+		DataKey:    settings.TokenDataKey,
+		Ciphertext: settings.AIToken,
+	}
+}
+
+// GetMaskedUserSettings is GetUserSettings for responses to the user: each
+// token is replaced by its masked form and a fingerprint.
+func GetMaskedUserSettings(userID int) ([]*models.UserSettings, error) {
+	settings, err := GetUserSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range settings {
+		if s.AIToken != "" {
+			s.TokenFingerprint = lib.TokenFingerprint(s.AIToken)
+			s.AIToken = lib.MaskToken(s.AIToken)
+		}
+	}
+	return settings, nil
+}
+
+// EncryptExistingAITokens encrypts every token still stored in plaintext and
+// returns how many were encrypted. It is safe to run more than once.
+func EncryptExistingAITokens() (int, error) {
+	if !envelope.Enabled() {
+		return 0, ErrAITokenKeysNotLoaded
+	}
+	encrypted := 0
+	for {
+		settingsList, err := mysql.GetConnection().GetPlaintextAITokens(aiTokenBatchSize)
+		if err != nil {
+			return encrypted, err
+		}
+		progressed := false
+		for _, settings := range settingsList {
+			plaintext := settings.AIToken
+			if err = sealAIToken(settings.UserID, settings); err != nil {
+				return encrypted, err
+			}
+			replaced, err := mysql.GetConnection().ReplaceAIToken(*settings, plaintext)
+			if err != nil {
+				return encrypted, err
+			}
+			if replaced {
+				encrypted++
+				progressed = true
+			}
+		}
+		if len(settingsList) < aiTokenBatchSize || !progressed {
+			return encrypted, nil
+		}
+	}
+}
+
+// ReencryptAITokens re-wraps the data keys of tokens wrapped with a master
+// key other than the active one, so a retired key can be removed from the key
+// file once no row uses it. It runs until the process exits, and does
+// nothing while encryption is disabled.
+func ReencryptAITokens() {
+	if !envelope.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(*aiTokenReencryptInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if rewrapped, err := rewrapAITokens(); err != nil {
+			logrus.Errorf("Failed to re-encrypt AI tokens: %v", err)
+		} else if rewrapped > 0 {
+			logrus.Infof("Re-encrypted %d AI tokens with key %q", rewrapped, envelope.ActiveKeyID())
+		}
+	}
+}
+
+func rewrapAITokens() (int, error) {
+	rewrapped := 0
+	for {
+		settingsList, err := mysql.GetConnection().GetAITokensToRewrap(envelope.ActiveKeyID(), aiTokenBatchSize)
+		if err != nil {
+			return rewrapped, err
+		}
+		progressed := false
+		for _, settings := range settingsList {
+			sealed, err := envelope.Rewrap(aiTokenSealed(settings))
+			if err != nil {
+				return rewrapped, err
+			}
+			updated := *settings
+			updated.TokenKeyID = sealed.KeyID
+			updated.TokenDataKey = sealed.DataKey
+			replaced, err := mysql.GetConnection().ReplaceAIToken(updated, settings.AIToken)
+			if err != nil {
+				return rewrapped, err
+			}
+			if replaced {
+				rewrapped++
+				progressed = true
+			}
+		}
+		if len(settingsList) < aiTokenBatchSize || !progressed {
+			return rewrapped, nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"time"
+)
+
+// Purposes of one-time codes. A code is only accepted for the purpose it
+// was issued for.
+const (
+	purposeVerifyEmail    = "verify_email"
+	purposeResetPassword  = "reset_password"
+	purposeLoginLink      = "login_link"
+	purposeSecureAccount  = "secure_account"
+	purposeChangeEmail    = "change_email"
+	purposeRevertEmail    = "revert_email"
+	purposeRestoreAccount = "restore_account"
+)
+
+var (
+	verificationCodeTTL         = flag.Duration("verificationCodeTTL", 15*time.Minute, "Lifetime of emailed verification and reset codes")
+	verificationCodeMaxAttempts = flag.Int("verificationCodeMaxAttempts", 5, "Wrong guesses after which a verification or reset code is burned")
+	verificationCodePepper      = flag.String("verificationCodePepper", "", "Secret of at least 32 bytes mixed into stored verification code hashes, required")
+
+	ErrInvalidVerificationCode = errors.New("code is invalid or expired, request a new one")
+	ErrWeakCodePepper          = fmt.Errorf("verificationCodePepper must be at least %d bytes", minCodePepperLength)
+)
+
+// minCodePepperLength keeps the pepper out of reach of enumeration: without
+// it the 90,000 possible codes are reversed from a leaked hash in no time.
+const minCodePepperLength = 32
+
+// CheckConfig reports settings the service cannot run with. It must be
+// called after the flags are parsed.
+func CheckConfig() error {
+	if len(*verificationCodePepper) < minCodePepperLength {
+		return ErrWeakCodePepper
+	}
+	return nil
+}
+
+// issueVerificationCode stores a new code for the purpose, replacing an
+// earlier one of the same purpose, and returns the code to email.
+func issueVerificationCode(userID int, purpose, payload string) (string, error) {
+	code := lib.GenerateSecureVerificationCode()
+	codeHash := lib.HashVerificationCode(*verificationCodePepper, code)
+	if err := mysql.GetConnection().IssueOneTimeCode(userID, purpose, codeHash, payload, *verificationCodeTTL); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// checkVerificationCode spends one attempt on the user's active code of the
+// purpose and uses the code up when it matches. It returns the payload the
+// code was issued with.
+func checkVerificationCode(userID int, purpose, code string) (string, error) {
+	stored, err := mysql.GetConnection().UseOneTimeCodeAttempt(userID, purpose, *verificationCodeMaxAttempts)
+	if err != nil {
+		return "", err
+	}
+	if stored == nil || !lib.VerificationCodeMatches(*verificationCodePepper, code, stored.Hash) {
+		return "", ErrInvalidVerificationCode
+	}
+
+	consumed, err := mysql.GetConnection().ConsumeOneTimeCode(stored.ID)
+	if err != nil {
+		return "", err
+	}
+	if !consumed {
+		return "", ErrInvalidVerificationCode
+	}
+	return stored.Payload, nil
+}
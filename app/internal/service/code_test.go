@@ -0,0 +1,27 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigRequiresPepper(t *testing.T) {
+	previous := *verificationCodePepper
+	t.Cleanup(func() { _ = flag.Set("verificationCodePepper", previous) })
+
+	for pepper, want := range map[string]error{
+		"":             ErrWeakCodePepper,
+		"short-pepper": ErrWeakCodePepper,
+		strings.Repeat("p", minCodePepperLength-1): ErrWeakCodePepper,
+		strings.Repeat("p", minCodePepperLength):   nil,
+	} {
+		if err := flag.Set("verificationCodePepper", pepper); err != nil {
+			t.Fatalf("set pepper: %v", err)
+		}
+		if err := CheckConfig(); !errors.Is(err, want) {
+			t.Errorf("pepper of %d bytes: err = %v, want %v", len(pepper), err, want)
+		}
+	}
+}
@@ -13,12 +13,54 @@ var (
 	SMTPPass   = flag.String("SMTPPass", "", "SMTP password")
 	SMTPServer = flag.String("SMTPServer", "", "SMTP server address")
 	SMTPPort   = flag.String("SMTPPort", "", "SMTP server port")
+
+	// sendMail delivers a message; tests replace it.
+	sendMail = smtp.SendMail
 )
 
 func sendVerificationEmail(toEmail, code, subject, bodyMessage string) error {
+	return sendEmail(toEmail, subject, fmt.Sprintf("Your code: %s\n\n%s", code, bodyMessage))
+}
+
+func sendLoginLinkEmail(toEmail, link string) error {
+	body := fmt.Sprintf("Open this link to sign in:\n\n%s\n\nThe link works once and expires in %s. If you did not ask for it, ignore this email.", link, *loginLinkTTL)
+	return sendEmail(toEmail, "Your sign-in link", body)
+}
+
+func sendLockoutEmail(toEmail string) error {
+	body := "Your account was temporarily locked after too many failed sign-in attempts.\n\n" +
+		"If this was not you, someone may be guessing your password. Consider resetting it once the lock expires."
+	return sendEmail(toEmail, "Your account was locked", body)
+}
+
+func sendAccountExistsEmail(toEmail string) error {
+	body := "Someone tried to create an account with this address, but you already have one.\n\n" +
+		"If it was you, sign in instead or reset your password. Otherwise you can ignore this email."
+	return sendEmail(toEmail, "You already have an account", body)
+}
+
+func sendPasswordChangedEmail(toEmail, link string) error {
+	body := fmt.Sprintf("The password of your account was just changed.\n\n"+
+		"If this wasn't you, open this link to sign out all sessions and get a code to set a new password:\n\n%s", link)
+	return sendEmail(toEmail, "Your password was changed", body)
+}
+
+func sendEmailChangedEmail(toEmail, newEmail, link string) error {
+	body := fmt.Sprintf("The email address of your account was changed to %s.\n\n"+
+		"If this wasn't you, open this link to restore this address, sign out all sessions and get a code to set a new password:\n\n%s\n\n"+
+		"The link expires in %s.", newEmail, link, *emailRevertTTL)
+	return sendEmail(toEmail, "Your email address was changed", body)
+}
+
+func sendAccountDeletedEmail(toEmail, link string) error {
+	body := fmt.Sprintf("Your account was deleted and will be removed for good in %s.\n\n"+
+		"Until then you can restore it with this link:\n\n%s", *accountDeletionGracePeriod, link)
+	return sendEmail(toEmail, "Your account was deleted", body)
+}
+
+func sendEmail(toEmail, subject, body string) error {
 	from := *SMTPEmail
 	to := []string{toEmail}
-	body := fmt.Sprintf("Your code: %s\n\n%s", code, bodyMessage)
 
 	message := []byte("From: " + from + "\r\n" +
 		"To: " + strings.Join(to, ",") + "\r\n" +
@@ -30,7 +72,7 @@ func sendVerificationEmail(toEmail, code, subject, bodyMessage string) error {
 
 	auth := smtp.PlainAuth("", from, *SMTPPass, *SMTPServer)
 
-	err := smtp.SendMail(*SMTPServer+":"+*SMTPPort, auth, from, to, message)
+	err := sendMail(*SMTPServer+":"+*SMTPPort, auth, from, to, message)
 	if err != nil {
 		log.Println("Ошибка отправки email:", err)
 		return err
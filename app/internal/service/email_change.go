@@ -0,0 +1,142 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/sirupsen/logrus"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	emailRevertURL = flag.String("emailRevertURL", "http://localhost:8080/users/email/revert", "Page the email change revert link points to, the token is appended as the token query parameter")
+	emailRevertTTL = flag.Duration("emailRevertTTL", 72*time.Hour, "How long the previous address can undo an email change")
+
+	ErrInvalidEmail      = errors.New("new email is not a valid email address")
+	ErrSameEmail         = errors.New("new email is the current email")
+	ErrEmailTaken        = mysql.ErrEmailTaken
+	ErrInvalidRevertLink = errors.New("link is invalid, expired or already used")
+)
+
+// RequestEmailChange checks the password and sends a code to the new
+// address. The address only changes once ConfirmEmailChange gets that code,
+// so the user has to control the new mailbox.
+func RequestEmailChange(userID int, ip string, req models.ChangeEmailReq) error {
+	newEmail := strings.TrimSpace(req.NewEmail)
+	if !validEmail(newEmail) {
+		return ErrInvalidEmail
+	}
+	userData, err := mysql.GetConnection().GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(newEmail, userData.Email) {
+		return ErrSameEmail
+	}
+	if err = loginguard.Check(userData.Email, ip); err != nil {
+		return err
+	}
+	if err = verifyPassword(userData.Password, req.Password); err != nil {
+		recordLoginFailure(userData.Email, ip, true)
+		return err
+	}
+
+	_, err = mysql.GetConnection().GetUserByEmail(newEmail)
+	if err == nil {
+		if !*privacyMode {
+			return ErrEmailTaken
+		}
+		if err = sendAccountExistsEmail(newEmail); err != nil {
+			logrus.Errorf("Failed to send account exists email: %v", err)
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	code, err := issueVerificationCode(userID, purposeChangeEmail, newEmail)
+	if err != nil {
+		return err
+	}
+	if err = sendVerificationEmail(newEmail, code, "Confirmation of email change", "Enter this code to confirm your new email address"); err != nil {
+		logrus.Errorf("Failed to send verification email: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ConfirmEmailChange swaps the address to the one the code was sent to and
+// gives the previous address a link to undo the change. Access tokens carry
+// the address, so every session is signed out and the returned tokens
+// replace the ones of the calling session.
+func ConfirmEmailChange(userID int, code string) (*models.TokenPair, error) {
+	userData, err := mysql.GetConnection().GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	newEmail, err := checkVerificationCode(userID, purposeChangeEmail, code)
+	if err != nil {
+		return nil, err
+	}
+	if err = mysql.GetConnection().ChangeDataUser(newEmail, "", userID); err != nil {
+		return nil, err
+	}
+	recordAudit(userID, auditEmailChanged, newEmail)
+
+	if err = LogoutAll(userID); err != nil {
+		logrus.Errorf("Failed to revoke tokens after email change: %v", err)
+		return nil, err
+	}
+	tokens, err := issueTokenPair(userID, newEmail, "")
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := accountLink(*emailRevertURL, userID, purposeRevertEmail, userData.Email, *emailRevertTTL)
+	if err != nil {
+		logrus.Errorf("Failed to create email revert link: %v", err)
+		return tokens, nil
+	}
+	if err = sendEmailChangedEmail(userData.Email, newEmail, link); err != nil {
+		logrus.Errorf("Failed to send email changed email: %v", err)
+	}
+	return tokens, nil
+}
+
+// validEmail reports whether email is a bare address such as
+// user@example.com, without a display name or angle brackets.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// RevertEmailChange restores the previous address from a revert link. As the
+// change may have been made by someone else, every session is signed out and
+// a code to set a new password is sent to the restored address. The link is
+// only used up when the address is restored, so it can be retried when the
+// address has meanwhile been taken by another account.
+func RevertEmailChange(token string) error {
+	userID, secretHash, err := parseAccountLink(token, ErrInvalidRevertLink)
+	if err != nil {
+		return err
+	}
+	previousEmail, used, err := mysql.GetConnection().RestoreEmailWithToken(userID, purposeRevertEmail, secretHash)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidRevertLink
+	}
+	recordAudit(userID, auditEmailReverted, previousEmail)
+
+	if err = LogoutAll(userID); err != nil {
+		return err
+	}
+	return RequestResetPassword(previousEmail)
+}
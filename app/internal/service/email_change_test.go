@@ -0,0 +1,37 @@
+package service
+
+import (
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"testing"
+)
+
+func TestValidEmail(t *testing.T) {
+	for email, want := range map[string]bool{
+		"user@example.com":          true,
+		"first.last+tag@mail.co.uk": true,
+		"":                          false,
+		"user":                      false,
+		"user@":                     false,
+		"@example.com":              false,
+		"user example@example.com":  false,
+		"User <user@example.com>":   false,
+		"<user@example.com>":        false,
+		"user@example.com, a@b.com": false,
+	} {
+		if got := validEmail(email); got != want {
+			t.Errorf("validEmail(%q) = %v, want %v", email, got, want)
+		}
+	}
+}
+
+// A malformed address is rejected before the account is looked up or a
+// code is issued.
+func TestRequestEmailChangeRejectsMalformedEmail(t *testing.T) {
+	for _, email := range []string{"not-an-email", "User <user@example.com>", "   "} {
+		err := RequestEmailChange(42, "", models.ChangeEmailReq{NewEmail: email, Password: "correct horse battery"})
+		if !errors.Is(err, ErrInvalidEmail) {
+			t.Errorf("new email %q: err = %v, want ErrInvalidEmail", email, err)
+		}
+	}
+}
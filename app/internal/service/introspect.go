@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"strconv"
+	"time"
+)
+
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+// Introspect describes any token issued by this service as defined by
+// RFC 7662. Invalid, expired and revoked tokens are reported as inactive
+// without telling the caller why.
+func Introspect(token, tokenTypeHint string) *models.Introspection {
+	if tokenTypeHint == tokenTypeRefresh {
+		if resp := introspectRefreshToken(token); resp.Active {
+			return resp
+		}
+		return introspectAccessToken(token)
+	}
+
+	if resp := introspectAccessToken(token); resp.Active {
+		return resp
+	}
+	return introspectRefreshToken(token)
+}
+
+func introspectAccessToken(token string) *models.Introspection {
+	claims, err := jwt.ParseToken(token)
+	if err != nil {
+		return &models.Introspection{Active: false}
+	}
+
+	resp := &models.Introspection{
+		Active:    true,
+		TokenType: tokenTypeAccess,
+	}
+	if userID, ok := claims["userID"].(float64); ok {
+		resp.Sub = strconv.Itoa(int(userID))
+	}
+	resp.Email, _ = claims["email"].(string)
+	resp.Scope, _ = claims["scope"].(string)
+	resp.ClientID, _ = claims["client_id"].(string)
+	resp.Jti, _ = claims["jti"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		resp.Exp = int64(exp)
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		resp.Iat = int64(iat)
+	}
+	return resp
+}
+
+func introspectRefreshToken(token string) *models.Introspection {
+	stored, err := mysql.GetConnection().GetRefreshToken(lib.HashToken(token))
+	if err != nil || stored.IsExpired || stored.IsUsed || stored.IsRevoked {
+		return &models.Introspection{Active: false}
+	}
+
+	return &models.Introspection{
+		Active:    true,
+		Sub:       strconv.Itoa(stored.UserID),
+		Email:     stored.Email,
+		Exp:       time.Now().Unix() + stored.ExpiresIn,
+		TokenType: tokenTypeRefresh,
+	}
+}
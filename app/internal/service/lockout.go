@@ -0,0 +1,39 @@
+package service
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// recordLoginFailure counts a failed password or second-factor login. Errors are only logged,
+// the caller already fails the login. The owner is emailed when the account
+// gets locked, as long as the account exists.
+func recordLoginFailure(email, ip string, accountExists bool) {
+	locked, err := loginguard.Fail(email, ip)
+	if err != nil {
+		logrus.Errorf("Failed to record login failure: %v", err)
+		return
+	}
+	if !locked || !accountExists {
+		return
+	}
+	if err = sendLockoutEmail(email); err != nil {
+		logrus.Errorf("Failed to send lockout email: %v", err)
+	}
+}
+
+// UnlockLogin lifts the lockout and backoff of an account, a client IP or both.
+func UnlockLogin(req models.UnlockReq) error {
+	if req.Email != "" {
+		if err := loginguard.UnlockAccount(req.Email); err != nil {
+			return err
+		}
+	}
+	if req.IP != "" {
+		if err := loginguard.UnlockIP(req.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
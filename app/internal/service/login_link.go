@@ -0,0 +1,120 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"time"
+)
+
+var (
+	loginLinkURL        = flag.String("loginLinkURL", "http://localhost:8080/users/login/link/consume", "Page the magic link points to, the token is appended as the token query parameter")
+	loginLinkTTL        = flag.Duration("loginLinkTTL", 15*time.Minute, "Lifetime of magic sign-in links")
+	loginLinkRateLimit  = flag.Int("loginLinkRateLimit", 3, "How many magic links one address can request per loginLinkRateWindow")
+	loginLinkRateWindow = flag.Duration("loginLinkRateWindow", 15*time.Minute, "Window for loginLinkRateLimit")
+
+	ErrInvalidLoginLink     = errors.New("login link is invalid, expired or already used")
+	ErrLoginLinkRateLimited = errors.New("too many login links requested, try again later")
+)
+
+// RequestLoginLink emails a signed, single-use sign-in link to the address.
+// The link is a challenge token bound to the user ID and email; the jti is
+// recorded so it can be used only once.
+func RequestLoginLink(email string) error {
+	userData, err := mysql.GetConnection().GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	count, err := mysql.GetConnection().CountRecentOneTimeCodes(userData.ID, purposeLoginLink, *loginLinkRateWindow)
+	if err != nil {
+		return err
+	}
+	if count >= *loginLinkRateLimit {
+		return ErrLoginLinkRateLimited
+	}
+
+	token, jti, err := jwt.GenerateChallengeJWT(purposeLoginLink, email, userData.ID, *loginLinkTTL)
+	if err != nil {
+		return err
+	}
+	if err = mysql.GetConnection().IssueOneTimeCode(userData.ID, purposeLoginLink, lib.HashToken(jti), email, *loginLinkTTL); err != nil {
+		return err
+	}
+
+	link, err := loginLinkFor(token)
+	if err != nil {
+		return err
+	}
+	if err = sendLoginLinkEmail(email, link); err != nil {
+		logrus.Errorf("Failed to send login link email: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ConsumeLoginLink exchanges a magic link token for a login. Following the
+// link proves control of the mailbox, so an unverified account is verified
+// the same way VerifyCode does it. Users with 2FA still get an MFA challenge.
+func ConsumeLoginLink(token, ip string) (*models.LoginResponse, error) {
+	claims, err := jwt.ParseChallengeToken(token, purposeLoginLink)
+	if err != nil {
+		return nil, ErrInvalidLoginLink
+	}
+	userIDFloat, _ := claims["userID"].(float64)
+	email, _ := claims["email"].(string)
+	jti, _ := claims["jti"].(string)
+
+	userData, err := mysql.GetConnection().GetUserByID(int(userIDFloat))
+	if err != nil {
+		return nil, ErrInvalidLoginLink
+	}
+	if userData.Email != email {
+		return nil, ErrInvalidLoginLink
+	}
+
+	used, err := mysql.GetConnection().UseOneTimeToken(userData.ID, purposeLoginLink, lib.HashToken(jti), email)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidLoginLink
+	}
+
+	if !userData.IsVerify {
+		if err = mysql.GetConnection().VerifyUser(email); err != nil {
+			return nil, err
+		}
+	}
+
+	mfaEnabled, err := isTOTPEnabled(userData.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		return mfaChallenge(userData.ID, email)
+	}
+
+	tokens, err := issueTokenPair(userData.ID, email, "")
+	if err != nil {
+		return nil, err
+	}
+	recordLogin(userData.ID, loginMethodLoginLink, ip, true)
+	return &models.LoginResponse{TokenPair: tokens}, nil
+}
+
+func loginLinkFor(token string) (string, error) {
+	link, err := url.Parse(*loginLinkURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
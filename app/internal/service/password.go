@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/passwordhash"
+	"github.com/Dimoonevs/user-service/app/internal/passwordpolicy"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var (
+	secureAccountURL     = flag.String("secureAccountURL", "http://localhost:8080/users/account/secure", "Page the \"this wasn't me\" link points to, the token is appended as the token query parameter")
+	secureAccountLinkTTL = flag.Duration("secureAccountLinkTTL", 7*24*time.Hour, "Lifetime of the \"this wasn't me\" link in security notifications")
+
+	ErrInvalidSecureAccountLink = errors.New("link is invalid, expired or already used")
+)
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session is signed out, and the returned tokens replace the ones of
+// the calling session. Wrong current passwords count as failed logins.
+func ChangePassword(userID int, ip string, req models.ChangePasswordReq) (*models.TokenPair, error) {
+	userData, err := mysql.GetConnection().GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = loginguard.Check(userData.Email, ip); err != nil {
+		return nil, err
+	}
+	if err = verifyPassword(userData.Password, req.CurrentPassword); err != nil {
+		recordLoginFailure(userData.Email, ip, true)
+		return nil, err
+	}
+	// The policy is only checked once the caller proved the current password,
+	// so that its answers cannot be had without it. The email in the access
+	// token may predate an email change.
+	if err = passwordpolicy.Check(req.NewPassword, userData.Email); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := passwordhash.Hash(req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err = mysql.GetConnection().ChangeDataUser("", hashedPassword, userID); err != nil {
+		return nil, err
+	}
+
+	if err = LogoutAll(userID); err != nil {
+		logrus.Errorf("Failed to revoke tokens after password change: %v", err)
+		return nil, err
+	}
+	tokens, err := issueTokenPair(userID, userData.Email, "")
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(userID, auditPasswordChanged, "")
+	notifyPasswordChanged(userID, userData.Email)
+	return tokens, nil
+}
+
+// notifyPasswordChanged emails the owner with a link to undo a change they
+// did not make. Errors are only logged, the password is already changed.
+func notifyPasswordChanged(userID int, email string) {
+	link, err := accountLink(*secureAccountURL, userID, purposeSecureAccount, "", *secureAccountLinkTTL)
+	if err != nil {
+		logrus.Errorf("Failed to create secure account link: %v", err)
+		return
+	}
+	if err = sendPasswordChangedEmail(email, link); err != nil {
+		logrus.Errorf("Failed to send password changed email: %v", err)
+	}
+}
+
+// SecureAccount is the target of the "this wasn't me" link. It signs the
+// account out everywhere and emails a password reset code to the account.
+func SecureAccount(token string) error {
+	userID, _, err := useAccountLink(token, purposeSecureAccount, ErrInvalidSecureAccountLink)
+	if err != nil {
+		return err
+	}
+	userData, err := mysql.GetConnection().GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err = LogoutAll(userID); err != nil {
+		return err
+	}
+	recordAudit(userID, auditAccountSecured, "")
+	return RequestResetPassword(userData.Email)
+}
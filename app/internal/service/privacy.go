@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/passwordhash"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+var (
+	privacyMode = flag.Bool("privacyMode", false, "Answer register, resend, reset, code, login link and passkey requests the same way whether or not the email has an account")
+
+	ErrInvalidCredentials = errors.New("invalid email or password")
+
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+func PrivacyMode() bool {
+	return *privacyMode
+}
+
+// dummyPasswordHash is compared against when the email is unknown, so that
+// a login takes as long as one with a wrong password. It is made on first use
+// so it picks up the configured hashing parameters.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		var err error
+		if dummyHash, err = passwordhash.Hash("dummy password"); err != nil {
+			logrus.Errorf("Cannot hash dummy password: %v", err)
+		}
+	})
+	return dummyHash
+}
+
+// RunInBackground runs an email flow detached from the request, so the
+// response neither waits for SMTP nor depends on whether the address has an
+// account. The outcome is only logged.
+func RunInBackground(name string, flow func() error) {
+	go func() {
+		if err := flow(); err != nil {
+			logrus.Infof("Background %s finished with: %v", name, err)
+		}
+	}()
+}
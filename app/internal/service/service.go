@@ -1,25 +1,38 @@
 package service
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
 	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/passwordhash"
 	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
-	"github.com/Dimoonevs/user-service/app/pkg/jwt"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func RegisterUser(req models.UsersReq) (int, error) {
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	code := lib.GenerateSecureVerificationCode()
+	hashedPassword, err := passwordhash.Hash(req.Password)
+	if err != nil {
+		return 0, err
+	}
 	userData := models.UserData{
 		Email:    req.Email,
-		Password: string(hashedPassword),
-		Code:     code,
+		Password: hashedPassword,
 	}
 
 	userID, err := mysql.GetConnection().SaveUserData(userData)
+	if errors.Is(err, mysql.ErrUserExists) && *privacyMode {
+		if err := sendAccountExistsEmail(req.Email); err != nil {
+			logrus.Errorf("Failed to send account exists email: %v", err)
+		}
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	code, err := issueVerificationCode(userID, purposeVerifyEmail, "")
 	if err != nil {
 		return 0, err
 	}
@@ -33,14 +46,17 @@ func RegisterUser(req models.UsersReq) (int, error) {
 }
 
 func SendVerificationEmailAgain(email string) error {
-	code := lib.GenerateSecureVerificationCode()
+	userData, err := mysql.GetConnection().GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
 
-	err := mysql.GetConnection().SetCodeByEmail(email, code)
+	code, err := issueVerificationCode(userData.ID, purposeVerifyEmail, "")
 	if err != nil {
 		return err
 	}
 
-	if err := sendVerificationEmail(email, code, "Confirmation of registration", "Enter this code to confirm your registration"); err != nil {
+	if err = sendVerificationEmail(email, code, "Confirmation of registration", "Enter this code to confirm your registration"); err != nil {
 		logrus.Errorf("Failed to send verification email: %v", err)
 		return err
 	}
@@ -49,11 +65,14 @@ func SendVerificationEmailAgain(email string) error {
 
 func VerifyCode(req models.UsersReq) error {
 	userData, err := mysql.GetConnection().GetUserByEmail(req.Email)
+	if errors.Is(err, sql.ErrNoRows) && *privacyMode {
+		return ErrInvalidVerificationCode
+	}
 	if err != nil {
 		return err
 	}
-	if req.Code != userData.Code {
-		return fmt.Errorf("failed to verify code for user with email: %s", req.Email)
+	if _, err = checkVerificationCode(userData.ID, purposeVerifyEmail, req.Code); err != nil {
+		return err
 	}
 
 	if err = mysql.GetConnection().VerifyUser(req.Email); err != nil {
@@ -63,24 +82,82 @@ func VerifyCode(req models.UsersReq) error {
 	return nil
 }
 
-func LoginUser(req models.UsersReq) (string, error) {
+func LoginUser(req models.UsersReq, ip string) (*models.LoginResponse, error) {
+	if err := loginguard.Check(req.Email, ip); err != nil {
+		return nil, err
+	}
+
 	userData, err := mysql.GetConnection().GetUserByEmail(req.Email)
 	if err != nil {
-		return "", err
+		recordLoginFailure(req.Email, ip, false)
+		if *privacyMode {
+			// Spend the same time as a wrong password would.
+			_ = verifyPassword(dummyPasswordHash(), req.Password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err = verifyPassword(userData.Password, req.Password); err != nil {
+		recordLoginFailure(req.Email, ip, true)
+		recordLogin(userData.ID, loginMethodPassword, ip, false)
+		if *privacyMode {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
 	}
 	if !userData.IsVerify {
-		return "", fmt.Errorf("failed to verify email")
+		return nil, fmt.Errorf("failed to verify email")
+	}
+	rehashPassword(userData, req.Password)
+
+	mfaEnabled, err := isTOTPEnabled(userData.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		// The failure counter is reset by LoginWithMFA once the second
+		// factor is also correct.
+		return mfaChallenge(userData.ID, req.Email)
+	}
+	if err = loginguard.Succeed(req.Email); err != nil {
+		logrus.Errorf("Failed to reset login failures: %v", err)
+	}
+
+	tokens, err := issueTokenPair(userData.ID, req.Email, "")
+	if err != nil {
+		return nil, err
 	}
+	recordLogin(userData.ID, loginMethodPassword, ip, true)
+	return &models.LoginResponse{TokenPair: tokens}, nil
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(req.Password))
+func verifyPassword(hash, password string) error {
+	ok, err := passwordhash.Verify(hash, password)
 	if err != nil {
-		return "", fmt.Errorf("failed to compare password")
+		logrus.Errorf("Cannot verify password hash: %v", err)
 	}
-	token, err := jwt.GenerateJWT(req.Email, userData.ID)
+	if !ok {
+		return fmt.Errorf("failed to compare password")
+	}
+	return nil
+}
+
+// rehashPassword upgrades a hash made with an older algorithm or weaker
+// parameters, using the password the user just proved. Failures are logged
+// and retried on the next login.
+func rehashPassword(userData *models.UserData, password string) {
+	if !passwordhash.NeedsRehash(userData.Password) {
+		return
+	}
+	hashedPassword, err := passwordhash.Hash(password)
 	if err != nil {
-		return "", err
+		logrus.Errorf("Cannot rehash password: %v", err)
+		return
+	}
+	if err = mysql.GetConnection().ReplacePasswordHash(userData.ID, userData.Password, hashedPassword); err != nil {
+		logrus.Errorf("Cannot store rehashed password: %v", err)
 	}
-	return token, nil
 }
 
 func RequestResetPassword(email string) error {
@@ -88,9 +165,8 @@ func RequestResetPassword(email string) error {
 	if err != nil {
 		return fmt.Errorf("failed to reset password for user with email: %s", email)
 	}
-	code := lib.GenerateSecureVerificationCode()
-
-	if err = mysql.GetConnection().UpdateVerifyCode(userData.ID, code); err != nil {
+	code, err := issueVerificationCode(userData.ID, purposeResetPassword, "")
+	if err != nil {
 		return fmt.Errorf("failed to reset password for user with email: %s", email)
 	}
 
@@ -103,23 +179,41 @@ func RequestResetPassword(email string) error {
 
 func ConfirmResetPassword(req models.UsersReq) error {
 	userData, err := mysql.GetConnection().GetUserByEmail(req.Email)
+	if errors.Is(err, sql.ErrNoRows) && *privacyMode {
+		return ErrInvalidVerificationCode
+	}
 	if err != nil {
 		return err
 	}
-	if req.Code != userData.Code {
-		return fmt.Errorf("failed to verify code for user with email: %s", req.Email)
+	if _, err = checkVerificationCode(userData.ID, purposeResetPassword, req.Code); err != nil {
+		return err
 	}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err = mysql.GetConnection().ChangeDataUser("", string(hashedPassword), userData.ID); err != nil {
+	hashedPassword, err := passwordhash.Hash(req.Password)
+	if err != nil {
 		return err
 	}
+	if err = mysql.GetConnection().ChangeDataUser("", hashedPassword, userData.ID); err != nil {
+		return err
+	}
+
+	if err = LogoutAll(userData.ID); err != nil {
+		logrus.Errorf("Failed to revoke tokens after password reset: %v", err)
+		return err
+	}
+	recordAudit(userData.ID, auditPasswordReset, "")
 	return nil
 }
 
 // with token
 
 func UserSettings(userID int, req models.UserSettings) error {
+	if err := validateAISettings(req); err != nil {
+		return err
+	}
+	if err := sealAIToken(userID, &req); err != nil {
+		return err
+	}
 	if err := mysql.GetConnection().SetUserSettings(userID, req); err != nil {
 		return err
 	}
@@ -127,14 +221,25 @@ func UserSettings(userID int, req models.UserSettings) error {
 }
 
 func GetUserSettings(userID int) (settings []*models.UserSettings, err error) {
-	settings, err = mysql.GetConnection().GetUserSettings(userID)
+	settings, err = accountStorage().GetUserSettings(userID)
 	if err != nil {
 		return nil, err
 	}
+	for _, s := range settings {
+		if err = openAIToken(s); err != nil {
+			return nil, err
+		}
+	}
 	return settings, nil
 }
 
 func UpdateUserSettings(userID int, settings models.UserSettings) error {
+	if err := validateAISettingsUpdate(userID, &settings); err != nil {
+		return err
+	}
+	if err := sealAIToken(userID, &settings); err != nil {
+		return err
+	}
 	if err := mysql.GetConnection().UpdateUserSettings(userID, settings); err != nil {
 		return err
 	}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/clients"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const serviceActionGetAISettings = "get_ai_settings"
+
+var ErrInvalidScope = errors.New("requested scope is not allowed for the client")
+
+// IssueClientToken runs the OAuth client credentials grant for an
+// authenticated client. The token gets the requested scopes, or all scopes
+// of the client when none are requested.
+func IssueClientToken(client *clients.Client, requestedScope string) (*models.ClientToken, error) {
+	scopes := strings.Fields(requestedScope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !client.HasScope(scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+	token, err := jwt.GenerateClientJWT(client.ID, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ClientToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(jwt.ClientTokenTTL().Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// GetServiceAISettings returns one settings entry of a user with the
+// cleartext token, for a backend service that runs jobs on the user's behalf.
+// Every call is written to the service access log, and successful ones also
+// to the audit trail of the user.
+func GetServiceAISettings(caller models.ServiceCaller, userID, settingsID int) (*models.UserSettings, error) {
+	settings, err := getServiceAISettings(userID, settingsID)
+	if logErr := mysql.GetConnection().SaveServiceAccess(caller, serviceActionGetAISettings, userID, settingsID, err == nil); logErr != nil {
+		// An access that cannot be logged is not served.
+		logrus.Errorf("Failed to log service access of %s: %v", caller.ClientID, logErr)
+		return nil, logErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(userID, auditAITokenRevealed, fmt.Sprintf("client %s, settings %d", caller.ClientID, settingsID))
+	return settings, nil
+}
+
+func getServiceAISettings(userID, settingsID int) (*models.UserSettings, error) {
+	settings, err := mysql.GetConnection().GetUserSettingsByID(userID, settingsID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSettingsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = openAIToken(settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/revocation"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	for name, value := range map[string]string{
+		"webauthnRPID":       testRPID,
+		"webauthnOrigins":    testOrigin,
+		"secretKey":          "service-test-secret",
+		"revocationStore":    "memory",
+		"revocationCacheTTL": "0",
+		"loginGuardStore":    "memory",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			panic(err)
+		}
+	}
+	if err := jwt.LoadKeys(); err != nil {
+		panic(err)
+	}
+	jwt.SetRevocationChecker(revocation.GetStore().IsRevoked)
+	os.Exit(m.Run())
+}
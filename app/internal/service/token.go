@@ -0,0 +1,152 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/Dimoonevs/user-service/app/internal/revocation"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var (
+	refreshTokenTTL = flag.Duration("refreshTokenTTL", 30*24*time.Hour, "Lifetime of issued refresh tokens")
+
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// refreshTokenStore is the part of the storage that keeps refresh tokens.
+type refreshTokenStore interface {
+	SaveRefreshToken(userID int, familyID, tokenHash string, ttl time.Duration) error
+	GetRefreshToken(tokenHash string) (*models.RefreshToken, error)
+	RotateRefreshToken(oldID, userID int, familyID, newTokenHash string, ttl time.Duration) (bool, error)
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int) error
+}
+
+// refreshTokenStorage returns the refresh token store; tests replace it.
+var refreshTokenStorage = func() refreshTokenStore {
+	return mysql.GetConnection()
+}
+
+// issueTokenPair signs a new access token and stores a new refresh token.
+// An empty familyID starts a new refresh token family (a new login session).
+func issueTokenPair(userID int, email, familyID string) (*models.TokenPair, error) {
+	if familyID == "" {
+		var err error
+		if familyID, err = lib.GenerateSecureToken(24); err != nil {
+			return nil, err
+		}
+	}
+
+	refreshToken, err := lib.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err = refreshTokenStorage().SaveRefreshToken(userID, familyID, lib.HashToken(refreshToken), *refreshTokenTTL); err != nil {
+		return nil, err
+	}
+
+	return newTokenPair(userID, email, refreshToken)
+}
+
+func newTokenPair(userID int, email, refreshToken string) (*models.TokenPair, error) {
+	accessToken, err := jwt.GenerateJWT(email, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(jwt.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. Every refresh
+// token can be used once; presenting an already used one revokes its whole
+// family, since either the client or an attacker holds a stolen copy.
+func RefreshTokens(refreshToken string) (*models.TokenPair, error) {
+	stored, err := refreshTokenStorage().GetRefreshToken(lib.HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.IsRevoked {
+		return nil, ErrInvalidRefreshToken
+	}
+	if stored.IsUsed {
+		return nil, revokeReusedFamily(stored)
+	}
+	if stored.IsExpired {
+		return nil, ErrRefreshTokenExpired
+	}
+
+	newRefreshToken, err := lib.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := refreshTokenStorage().RotateRefreshToken(stored.ID, stored.UserID, stored.FamilyID, lib.HashToken(newRefreshToken), *refreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		return nil, revokeReusedFamily(stored)
+	}
+
+	return newTokenPair(stored.UserID, stored.Email, newRefreshToken)
+}
+
+func revokeReusedFamily(stored *models.RefreshToken) error {
+	logrus.Warnf("Refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+	if err := refreshTokenStorage().RevokeRefreshTokenFamily(stored.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// Logout revokes the access token identified by jti and, when given, the
+// refresh token family of the same session.
+func Logout(userID int, jti string, expiresAt time.Time, refreshToken string) error {
+	if jti == "" {
+		return errors.New("token cannot be revoked")
+	}
+	if err := revocation.GetStore().Revoke(jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+	stored, err := refreshTokenStorage().GetRefreshToken(lib.HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stored.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+	return refreshTokenStorage().RevokeRefreshTokenFamily(stored.FamilyID)
+}
+
+// LogoutAll revokes every access and refresh token issued to the user so far.
+// Access tokens carry iat to the millisecond, so tokens the caller issues
+// afterwards, such as the replacement pair of ChangePassword, are not caught
+// by the cut-off.
+func LogoutAll(userID int) error {
+	if err := revocation.GetStore().RevokeAll(userID, time.Now()); err != nil {
+		return err
+	}
+	return refreshTokenStorage().RevokeUserRefreshTokens(userID)
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryRefreshTokens keeps refresh tokens the way the refresh_tokens table
+// does, for tests that do not run against MySQL.
+type memoryRefreshTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*memoryRefreshToken
+	nextID int
+	// beforeRotate runs before a rotation, to interleave a concurrent one.
+	beforeRotate func()
+}
+
+type memoryRefreshToken struct {
+	models.RefreshToken
+	hash      string
+	expiresAt time.Time
+}
+
+func useMemoryRefreshTokens(t *testing.T) *memoryRefreshTokens {
+	t.Helper()
+
+	store := &memoryRefreshTokens{tokens: make(map[string]*memoryRefreshToken)}
+	previous := refreshTokenStorage
+	refreshTokenStorage = func() refreshTokenStore { return store }
+	t.Cleanup(func() { refreshTokenStorage = previous })
+	return store
+}
+
+func (s *memoryRefreshTokens) SaveRefreshToken(userID int, familyID, tokenHash string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.save(userID, familyID, tokenHash, ttl)
+	return nil
+}
+
+func (s *memoryRefreshTokens) save(userID int, familyID, tokenHash string, ttl time.Duration) {
+	s.nextID++
+	s.tokens[tokenHash] = &memoryRefreshToken{
+		RefreshToken: models.RefreshToken{ID: s.nextID, UserID: userID, Email: "user@example.com", FamilyID: familyID},
+		hash:         tokenHash,
+		expiresAt:    time.Now().Add(ttl),
+	}
+}
+
+func (s *memoryRefreshTokens) GetRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.tokens[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	token := stored.RefreshToken
+	token.ExpiresIn = int64(time.Until(stored.expiresAt).Seconds())
+	token.IsExpired = time.Now().After(stored.expiresAt)
+	return &token, nil
+}
+
+func (s *memoryRefreshTokens) RotateRefreshToken(oldID, userID int, familyID, newTokenHash string, ttl time.Duration) (bool, error) {
+	if s.beforeRotate != nil {
+		s.beforeRotate()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.tokens {
+		if stored.ID != oldID {
+			continue
+		}
+		if stored.IsUsed || stored.IsRevoked {
+			return false, nil
+		}
+		stored.IsUsed = true
+		s.save(userID, familyID, newTokenHash, ttl)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryRefreshTokens) RevokeRefreshTokenFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.tokens {
+		if stored.FamilyID == familyID {
+			stored.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryRefreshTokens) RevokeUserRefreshTokens(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.tokens {
+		if stored.UserID == userID {
+			stored.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryRefreshTokens) expire(tokenHash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[tokenHash].expiresAt = time.Now().Add(-time.Second)
+}
+
+func mustIssue(t *testing.T) *models.TokenPair {
+	t.Helper()
+
+	pair, err := issueTokenPair(42, "user@example.com", "")
+	if err != nil {
+		t.Fatalf("issueTokenPair: %v", err)
+	}
+	return pair
+}
+
+func mustRefresh(t *testing.T, refreshToken string) *models.TokenPair {
+	t.Helper()
+
+	pair, err := RefreshTokens(refreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+	return pair
+}
+
+func TestRefreshTokensRotates(t *testing.T) {
+	useMemoryRefreshTokens(t)
+
+	first := mustIssue(t)
+	second := mustRefresh(t, first.RefreshToken)
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+	claims, err := jwt.ParseToken(second.AccessToken)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims["userID"] != float64(42) {
+		t.Errorf("userID = %v, want 42", claims["userID"])
+	}
+
+	mustRefresh(t, second.RefreshToken)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	useMemoryRefreshTokens(t)
+
+	first := mustIssue(t)
+	other := mustIssue(t)
+	second := mustRefresh(t, first.RefreshToken)
+	third := mustRefresh(t, second.RefreshToken)
+
+	// Replaying a used token revokes every token of its family, including the
+	// current one held by whoever refreshed last.
+	if _, err := RefreshTokens(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replayed token: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := RefreshTokens(third.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("latest token of the family: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := RefreshTokens(second.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("revoked token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	// Other sessions of the same user are left alone.
+	mustRefresh(t, other.RefreshToken)
+}
+
+func TestRefreshTokenConcurrentRotationCountsAsReuse(t *testing.T) {
+	store := useMemoryRefreshTokens(t)
+
+	first := mustIssue(t)
+	// Another request rotates the same token between the lookup and the
+	// rotation of this one.
+	store.beforeRotate = func() {
+		store.beforeRotate = nil
+		mustRefresh(t, first.RefreshToken)
+	}
+	if _, err := RefreshTokens(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("err = %v, want ErrRefreshTokenReused", err)
+	}
+	for _, stored := range store.tokens {
+		if !stored.IsRevoked {
+			t.Errorf("token %d of the family is not revoked", stored.ID)
+		}
+	}
+}
+
+func TestRefreshTokenRejectsUnknownAndExpired(t *testing.T) {
+	store := useMemoryRefreshTokens(t)
+
+	if _, err := RefreshTokens("unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("unknown token: err = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	pair := mustIssue(t)
+	for hash := range store.tokens {
+		store.expire(hash)
+	}
+	if _, err := RefreshTokens(pair.RefreshToken); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Errorf("expired token: err = %v, want ErrRefreshTokenExpired", err)
+	}
+}
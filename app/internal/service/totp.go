@@ -0,0 +1,269 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/Dimoonevs/user-service/app/internal/revocation"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	"sync"
+	"time"
+)
+
+const (
+	mfaPurpose        = "mfa_required"
+	recoveryCodeCount = 10
+	maxMFAAttempts    = 5
+)
+
+var (
+	totpIssuer  = flag.String("totpIssuer", "user-service", "Issuer name shown in authenticator apps")
+	mfaTokenTTL = flag.Duration("mfaTokenTTL", 5*time.Minute, "Lifetime of the challenge token returned by login when 2FA is enabled")
+
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrTOTPSetupNotStarted = errors.New("two-factor setup was not started")
+
+	mfaFailures = &mfaFailureCounter{counts: make(map[string]mfaFailureCount)}
+)
+
+func SetupTOTP(userID int, email string) (*models.TOTPSetup, error) {
+	totp, err := mysql.GetConnection().GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp != nil && totp.IsConfirmed {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := lib.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err = mysql.GetConnection().SavePendingTOTP(userID, secret); err != nil {
+		return nil, err
+	}
+
+	uri := lib.TOTPURI(*totpIssuer, email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TOTPSetup{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRPNG:      png,
+	}, nil
+}
+
+// ConfirmTOTP enables 2FA once the user proves the authenticator app works,
+// and returns a fresh set of recovery codes that are shown only once.
+func ConfirmTOTP(userID int, code string) ([]string, error) {
+	totp, err := mysql.GetConnection().GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp == nil {
+		return nil, ErrTOTPSetupNotStarted
+	}
+	if totp.IsConfirmed {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if err = useTOTPCode(totp, code, true); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := lib.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, lib.HashToken(lib.NormalizeRecoveryCode(code)))
+	}
+	if err = mysql.GetConnection().ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	recordAudit(userID, auditTOTPEnabled, "")
+	return codes, nil
+}
+
+// DisableTOTP requires both the password and a current second factor. Wrong
+// passwords count as failed logins.
+func DisableTOTP(userID int, ip string, req models.MFAReq) error {
+	userData, err := mysql.GetConnection().GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if err = loginguard.Check(userData.Email, ip); err != nil {
+		return err
+	}
+	if err = verifyPassword(userData.Password, req.Password); err != nil {
+		recordLoginFailure(userData.Email, ip, true)
+		return err
+	}
+
+	enabled, err := isTOTPEnabled(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTOTPNotEnabled
+	}
+	if err = verifySecondFactor(userID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	if err = mysql.GetConnection().DeleteTOTP(userID); err != nil {
+		return err
+	}
+	recordAudit(userID, auditTOTPDisabled, "")
+	return nil
+}
+
+// LoginWithMFA finishes a login started by LoginUser for users with 2FA.
+// Wrong codes count towards the account lockout like wrong passwords, so
+// starting a new challenge does not buy more guesses.
+func LoginWithMFA(req models.MFAReq, ip string) (*models.TokenPair, error) {
+	claims, err := jwt.ParseChallengeToken(req.MFAToken, mfaPurpose)
+	if err != nil {
+		return nil, err
+	}
+	userIDFloat, _ := claims["userID"].(float64)
+	userID := int(userIDFloat)
+	email, _ := claims["email"].(string)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	expiresAt := time.Unix(int64(exp), 0)
+
+	if err = loginguard.Check(email, ip); err != nil {
+		return nil, err
+	}
+	if err = verifySecondFactor(userID, req.Code, req.RecoveryCode); err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) {
+			return nil, err
+		}
+		recordLogin(userID, loginMethodMFA, ip, false)
+		recordLoginFailure(email, ip, true)
+		if mfaFailures.add(jti, expiresAt) >= maxMFAAttempts {
+			mfaFailures.reset(jti)
+			_ = revocation.GetStore().Revoke(jti, userID, expiresAt)
+		}
+		return nil, err
+	}
+
+	// The challenge token is single-use.
+	mfaFailures.reset(jti)
+	if err = revocation.GetStore().Revoke(jti, userID, expiresAt); err != nil {
+		return nil, err
+	}
+	if err = loginguard.Succeed(email); err != nil {
+		logrus.Errorf("Failed to reset login failures: %v", err)
+	}
+	tokens, err := issueTokenPair(userID, email, "")
+	if err != nil {
+		return nil, err
+	}
+	recordLogin(userID, loginMethodMFA, ip, true)
+	return tokens, nil
+}
+
+func mfaChallenge(userID int, email string) (*models.LoginResponse, error) {
+	token, _, err := jwt.GenerateChallengeJWT(mfaPurpose, email, userID, *mfaTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoginResponse{
+		MFARequired: true,
+		MFAToken:    token,
+	}, nil
+}
+
+func isTOTPEnabled(userID int) (bool, error) {
+	totp, err := accountStorage().GetTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	return totp != nil && totp.IsConfirmed, nil
+}
+
+func verifySecondFactor(userID int, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		used, err := mysql.GetConnection().UseRecoveryCode(userID, lib.HashToken(lib.NormalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	totp, err := mysql.GetConnection().GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if totp == nil || !totp.IsConfirmed {
+		return ErrTOTPNotEnabled
+	}
+	return useTOTPCode(totp, code, false)
+}
+
+func useTOTPCode(totp *models.TOTP, code string, confirm bool) error {
+	step, ok := lib.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	fresh, err := mysql.GetConnection().UseTOTPStep(totp.UserID, step, confirm)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// mfaFailureCounter counts wrong codes per challenge token so a challenge
+// can be burned before its 6-digit code space is brute-forced. Entries are
+// dropped once their challenge token has expired.
+type mfaFailureCounter struct {
+	mu     sync.Mutex
+	counts map[string]mfaFailureCount
+}
+
+type mfaFailureCount struct {
+	failures  int
+	expiresAt time.Time
+}
+
+func (c *mfaFailureCounter) add(jti string, expiresAt time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, count := range c.counts {
+		if now.After(count.expiresAt) {
+			delete(c.counts, key)
+		}
+	}
+
+	count := c.counts[jti]
+	count.failures++
+	count.expiresAt = expiresAt
+	c.counts[jti] = count
+	return count.failures
+}
+
+func (c *mfaFailureCounter) reset(jti string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counts, jti)
+}
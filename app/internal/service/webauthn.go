@@ -0,0 +1,372 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/repo/mysql"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webauthnSessionTTL = 5 * time.Minute
+
+var (
+	webauthnRPID    = flag.String("webauthnRPID", "", "WebAuthn relying party ID, usually the site domain; empty disables passkeys")
+	webauthnRPName  = flag.String("webauthnRPName", "user-service", "WebAuthn relying party display name")
+	webauthnOrigins = flag.String("webauthnOrigins", "", "Comma separated origins allowed to use passkeys, e.g. https://example.com")
+
+	ErrPasskeysDisabled        = errors.New("passkeys are not configured")
+	ErrWebAuthnSessionNotFound = errors.New("passkey session not found or expired")
+	ErrPasskeyCloned           = errors.New("passkey signature counter did not increase, the passkey may have been cloned")
+
+	webAuthn     *webauthn.WebAuthn
+	webAuthnErr  error
+	webAuthnOnce sync.Once
+
+	webauthnSessions = &webauthnSessionStore{sessions: make(map[string]webauthnSession)}
+)
+
+func getWebAuthn() (*webauthn.WebAuthn, error) {
+	webAuthnOnce.Do(func() {
+		if *webauthnRPID == "" {
+			webAuthnErr = ErrPasskeysDisabled
+			return
+		}
+		var origins []string
+		for _, origin := range strings.Split(*webauthnOrigins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+		webAuthn, webAuthnErr = webauthn.New(&webauthn.Config{
+			RPID:          *webauthnRPID,
+			RPDisplayName: *webauthnRPName,
+			RPOrigins:     origins,
+			Timeouts: webauthn.TimeoutsConfig{
+				Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnSessionTTL, TimeoutUVD: webauthnSessionTTL},
+				Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webauthnSessionTTL, TimeoutUVD: webauthnSessionTTL},
+			},
+		})
+	})
+	return webAuthn, webAuthnErr
+}
+
+// passkeyUser adapts a user row and its stored credentials to webauthn.User.
+// The user handle is the numeric user ID, which carries no personal data.
+type passkeyUser struct {
+	id          int
+	email       string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.id)) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.email }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.email }
+func (u *passkeyUser) WebAuthnIcon() string                       { return "" }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func loadPasskeyUser(userData *models.UserData) (*passkeyUser, error) {
+	stored, err := mysql.GetConnection().GetPasskeyCredentials(userData.ID)
+	if err != nil {
+		return nil, err
+	}
+	user := &passkeyUser{id: userData.ID, email: userData.Email}
+	for _, data := range stored {
+		var credential webauthn.Credential
+		if err = json.Unmarshal(data, &credential); err != nil {
+			return nil, err
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+	return user, nil
+}
+
+func loadPasskeyUserByID(userID int) (*passkeyUser, error) {
+	userData, err := mysql.GetConnection().GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return loadPasskeyUser(userData)
+}
+
+func BeginPasskeyRegistration(userID int) (*models.WebAuthnCeremony, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return nil, err
+	}
+	user, err := loadPasskeyUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	options, session, err := beginPasskeyRegistration(wa, user)
+	if err != nil {
+		return nil, err
+	}
+	return webauthnSessions.start(session, options)
+}
+
+// beginPasskeyRegistration asks for a discoverable credential and excludes
+// the ones the user already has, so one authenticator is not added twice.
+func beginPasskeyRegistration(wa *webauthn.WebAuthn, user *passkeyUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.credentials {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	return wa.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+}
+
+func FinishPasskeyRegistration(userID int, sessionID, name string, body []byte) error {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return err
+	}
+	session, ok := webauthnSessions.take(sessionID)
+	if !ok {
+		return ErrWebAuthnSessionNotFound
+	}
+	user, err := loadPasskeyUserByID(userID)
+	if err != nil {
+		return err
+	}
+	credential, err := createPasskey(wa, user, session, body)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = fmt.Sprintf("Passkey %d", len(user.credentials)+1)
+	}
+	if err = mysql.GetConnection().SavePasskey(userID, lib.HashToken(string(credential.ID)), data, name); err != nil {
+		return err
+	}
+	recordAudit(userID, auditPasskeyRegistered, name)
+	return nil
+}
+
+func createPasskey(wa *webauthn.WebAuthn, user *passkeyUser, session webauthn.SessionData, body []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return wa.CreateCredential(user, session, parsed)
+}
+
+func ListPasskeys(userID int) ([]*models.Passkey, error) {
+	return mysql.GetConnection().ListPasskeys(userID)
+}
+
+func RenamePasskey(userID, passkeyID int, name string) error {
+	return mysql.GetConnection().RenamePasskey(userID, passkeyID, name)
+}
+
+func DeletePasskey(userID, passkeyID int) error {
+	if err := mysql.GetConnection().DeletePasskey(userID, passkeyID); err != nil {
+		return err
+	}
+	recordAudit(userID, auditPasskeyDeleted, strconv.Itoa(passkeyID))
+	return nil
+}
+
+// BeginPasskeyLogin starts an assertion. With an email the browser is offered
+// that user's credentials; without one any discoverable passkey can be used.
+// In privacy mode an unknown email and one without passkeys fail the same way.
+// A locked account or client IP cannot start one.
+func BeginPasskeyLogin(email, ip string) (*models.WebAuthnCeremony, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return nil, err
+	}
+
+	if email == "" {
+		options, session, err := wa.BeginDiscoverableLogin()
+		if err != nil {
+			return nil, err
+		}
+		return webauthnSessions.start(session, options)
+	}
+
+	if err = loginguard.Check(email, ip); err != nil {
+		return nil, err
+	}
+	userData, err := mysql.GetConnection().GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) && *privacyMode {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	user, err := loadPasskeyUser(userData)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.credentials) == 0 && *privacyMode {
+		return nil, ErrInvalidCredentials
+	}
+	options, session, err := wa.BeginLogin(user)
+	if err != nil {
+		return nil, err
+	}
+	return webauthnSessions.start(session, options)
+}
+
+// FinishPasskeyLogin verifies the assertion and issues the same tokens as
+// LoginUser. A passkey already proves possession and user verification, so
+// no second factor is asked for. An assertion that looks like it came from a
+// cloned authenticator is rejected, recorded in the audit log and counted as
+// a failed login.
+func FinishPasskeyLogin(sessionID string, body []byte, ip string) (*models.TokenPair, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return nil, err
+	}
+	session, ok := webauthnSessions.take(sessionID)
+	if !ok {
+		return nil, ErrWebAuthnSessionNotFound
+	}
+	user, credential, err := validatePasskeyAssertion(wa, session, body, loadPasskeyUserByID)
+	if errors.Is(err, ErrPasskeyCloned) {
+		recordLoginFailure(user.email, ip, true)
+		recordLogin(user.id, loginMethodPasskey, ip, false)
+		recordAudit(user.id, auditPasskeyCloneWarning, lib.HashToken(string(credential.ID)))
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userData, err := mysql.GetConnection().GetUserByID(user.id)
+	if err != nil {
+		return nil, err
+	}
+	// A discoverable login only learns the account from the assertion, so
+	// the guard is checked here rather than when the ceremony starts.
+	if err = loginguard.Check(userData.Email, ip); err != nil {
+		return nil, err
+	}
+	if !userData.IsVerify {
+		return nil, fmt.Errorf("failed to verify email")
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+	if err = mysql.GetConnection().UpdatePasskeyUsage(lib.HashToken(string(credential.ID)), data); err != nil {
+		return nil, err
+	}
+	if err = loginguard.Succeed(userData.Email); err != nil {
+		logrus.Errorf("Failed to reset login failures: %v", err)
+	}
+	tokens, err := issueTokenPair(userData.ID, userData.Email, "")
+	if err != nil {
+		return nil, err
+	}
+	recordLogin(userData.ID, loginMethodPasskey, ip, true)
+	return tokens, nil
+}
+
+// validatePasskeyAssertion verifies an assertion against its session. For a
+// discoverable login the user is resolved from the user handle with loadUser.
+// An assertion whose signature counter did not increase fails with
+// ErrPasskeyCloned; the user and credential are still returned so that the
+// attempt can be audited.
+func validatePasskeyAssertion(wa *webauthn.WebAuthn, session webauthn.SessionData, body []byte, loadUser func(userID int) (*passkeyUser, error)) (*passkeyUser, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user *passkeyUser
+	var credential *webauthn.Credential
+	if session.UserID == nil {
+		credential, err = wa.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.Atoi(string(userHandle))
+			if err != nil {
+				return nil, err
+			}
+			user, err = loadUser(userID)
+			return user, err
+		}, session, parsed)
+	} else {
+		var userID int
+		if userID, err = strconv.Atoi(string(session.UserID)); err != nil {
+			return nil, nil, err
+		}
+		if user, err = loadUser(userID); err != nil {
+			return nil, nil, err
+		}
+		credential, err = wa.ValidateLogin(user, session, parsed)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return user, credential, ErrPasskeyCloned
+	}
+	return user, credential, nil
+}
+
+type webauthnSession struct {
+	data      webauthn.SessionData
+	expiresAt time.Time
+}
+
+// webauthnSessionStore keeps ceremony state between the begin and finish
+// calls. Sessions live in process memory, so with several instances the
+// load balancer has to keep a client on the same one for the ceremony.
+type webauthnSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]webauthnSession
+}
+
+func (s *webauthnSessionStore) start(data *webauthn.SessionData, options interface{}) (*models.WebAuthnCeremony, error) {
+	id, err := lib.GenerateSecureToken(24)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+	s.sessions[id] = webauthnSession{data: *data, expiresAt: now.Add(webauthnSessionTTL)}
+
+	return &models.WebAuthnCeremony{SessionID: id, Options: options}, nil
+}
+
+// take returns the session and removes it, so every ceremony is single-use.
+func (s *webauthnSessionStore) take(id string) (webauthn.SessionData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	if !ok || time.Now().After(session.expiresAt) {
+		return webauthn.SessionData{}, false
+	}
+	return session.data, true
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"strconv"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is a software passkey: one P-256 credential with "none"
+// attestation and a signature counter, enough to drive both ceremonies
+// without a browser.
+type softAuthenticator struct {
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		t.Fatalf("generate credential id: %v", err)
+	}
+	return &softAuthenticator{credentialID: id, key: key}
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags|flagUserPresent|flagUserVerified)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatalf("marshal client data: %v", err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// create answers navigator.credentials.create() for the given options.
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	t.Helper()
+
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(flagAttestedData, attested),
+	})
+	if err != nil {
+		t.Fatalf("marshal attestation: %v", err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(clientData(t, "webauthn.create", options.Response.Challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get() for the given options.
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	t.Helper()
+
+	authData := a.authData(0, nil)
+	clientDataJSON := clientData(t, "webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("sign assertion: %v", err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    b64(clientDataJSON),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) response(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("marshal response: %v", err)
+	}
+	return body
+}
+
+// register runs a full registration ceremony through the session store and
+// returns the user holding the new credential.
+func register(t *testing.T, wa *webauthn.WebAuthn, authenticator *softAuthenticator) *passkeyUser {
+	t.Helper()
+
+	user := &passkeyUser{id: 42, email: "user@example.com"}
+	options, session, err := beginPasskeyRegistration(wa, user)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	if options.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Errorf("resident key = %q, want required", options.Response.AuthenticatorSelection.ResidentKey)
+	}
+	ceremony, err := webauthnSessions.start(session, options)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+
+	data, ok := webauthnSessions.take(ceremony.SessionID)
+	if !ok {
+		t.Fatal("registration session not found")
+	}
+	credential, err := createPasskey(wa, user, data, authenticator.create(t, options))
+	if err != nil {
+		t.Fatalf("create passkey: %v", err)
+	}
+	if string(credential.ID) != string(authenticator.credentialID) {
+		t.Errorf("credential id = %x, want %x", credential.ID, authenticator.credentialID)
+	}
+
+	user.credentials = append(user.credentials, *credential)
+	return user
+}
+
+func loaderFor(user *passkeyUser) func(int) (*passkeyUser, error) {
+	return func(userID int) (*passkeyUser, error) {
+		if userID != user.id {
+			return nil, errors.New("user not found: " + strconv.Itoa(userID))
+		}
+		return user, nil
+	}
+}
+
+func testWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+
+	wa, err := getWebAuthn()
+	if err != nil {
+		t.Fatalf("getWebAuthn: %v", err)
+	}
+	return wa
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+	user := register(t, wa, authenticator)
+
+	options, session, err := wa.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	authenticator.signCount = 1
+	got, credential, err := validatePasskeyAssertion(wa, *session, authenticator.get(t, options), loaderFor(user))
+	if err != nil {
+		t.Fatalf("validate assertion: %v", err)
+	}
+	if got.id != user.id {
+		t.Errorf("user id = %d, want %d", got.id, user.id)
+	}
+	if credential.Authenticator.SignCount != 1 {
+		t.Errorf("sign count = %d, want 1", credential.Authenticator.SignCount)
+	}
+}
+
+func TestPasskeyDiscoverableLogin(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+	user := register(t, wa, authenticator)
+
+	options, session, err := wa.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatalf("begin discoverable login: %v", err)
+	}
+	got, _, err := validatePasskeyAssertion(wa, *session, authenticator.get(t, options), loaderFor(user))
+	if err != nil {
+		t.Fatalf("validate assertion: %v", err)
+	}
+	if got.id != user.id {
+		t.Errorf("user id = %d, want %d", got.id, user.id)
+	}
+}
+
+func TestPasskeyLoginRejectsWrongChallenge(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+	user := register(t, wa, authenticator)
+
+	options, _, err := wa.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	_, session, err := wa.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	if _, _, err = validatePasskeyAssertion(wa, *session, authenticator.get(t, options), loaderFor(user)); err == nil {
+		t.Fatal("assertion for another challenge was accepted")
+	}
+}
+
+func TestPasskeyLoginRejectsClone(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newSoftAuthenticator(t)
+	user := register(t, wa, authenticator)
+
+	// The stored counter is ahead of the one the authenticator presents, as
+	// when a copy of the key signs after the original has been used.
+	user.credentials[0].Authenticator.SignCount = 5
+	authenticator.signCount = 3
+
+	options, session, err := wa.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	got, credential, err := validatePasskeyAssertion(wa, *session, authenticator.get(t, options), loaderFor(user))
+	if !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("err = %v, want ErrPasskeyCloned", err)
+	}
+	if got == nil || credential == nil {
+		t.Fatal("user and credential are needed to audit a clone warning")
+	}
+}
+
+func TestWebAuthnSessionIsSingleUse(t *testing.T) {
+	ceremony, err := webauthnSessions.start(&webauthn.SessionData{Challenge: "challenge"}, nil)
+	if err != nil {
+		t.Fatalf("start session: %v", err)
+	}
+	if _, ok := webauthnSessions.take(ceremony.SessionID); !ok {
+		t.Fatal("session not found")
+	}
+	if _, ok := webauthnSessions.take(ceremony.SessionID); ok {
+		t.Fatal("session was returned twice")
+	}
+}
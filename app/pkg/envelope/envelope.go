@@ -0,0 +1,261 @@
+// Package envelope encrypts small secrets at rest with AES-256-GCM envelope
+// encryption. Every secret gets its own random data key, and only the data
+// key is encrypted ("wrapped") with a master key from the key file, so a
+// master key rotation re-wraps data keys without touching the secrets.
+//
+// The service does not start without a key file unless
+// -allowPlaintextAITokens is set, which is meant for local development only.
+// Tokens stored in plaintext before the key file was deployed are encrypted
+// by running the binary with -encryptAITokens, which restart.sh does on every
+// deploy. A key can be generated with: echo "k1:$(openssl rand -base64 32)".
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+const keySize = 32
+
+var (
+	keyFileFlag        = flag.String("aiTokenKeyFile", "", "File with AES-256 master keys for AI tokens, one <id>:<base64 key> per line")
+	activeKeyIDFlag    = flag.String("aiTokenActiveKeyID", "", "Master key used to wrap new data keys, the last key in aiTokenKeyFile when empty")
+	allowPlaintextFlag = flag.Bool("allowPlaintextAITokens", false, "Start without aiTokenKeyFile and store AI tokens in plaintext, for local development only")
+
+	ErrUnknownKey = errors.New("unknown master key")
+	ErrNoKeyFile  = errors.New("aiTokenKeyFile is not set, set -allowPlaintextAITokens to store AI tokens in plaintext")
+
+	ring atomic.Pointer[keyring]
+)
+
+// Sealed is an encrypted secret as stored next to the row it belongs to.
+type Sealed struct {
+	// KeyID names the master key that wrapped DataKey.
+	KeyID string
+	// DataKey is the wrapped data key, base64 encoded.
+	DataKey string
+	// Ciphertext is the secret encrypted with the data key, base64 encoded.
+	Ciphertext string
+}
+
+type keyring struct {
+	activeID string
+	keys     map[string]cipher.AEAD
+}
+
+// LoadKeys reads the master keys. It must be called after the flags are
+// parsed and before any secret is encrypted or decrypted. A missing key file
+// is an error unless plaintext storage was allowed explicitly, in which case
+// encryption stays disabled, see Enabled.
+func LoadKeys() error {
+	if *keyFileFlag == "" {
+		if !*allowPlaintextFlag {
+			return ErrNoKeyFile
+		}
+		logrus.Warn("aiTokenKeyFile is not set, AI tokens are stored in plaintext")
+		return nil
+	}
+	data, err := os.ReadFile(*keyFileFlag)
+	if err != nil {
+		return err
+	}
+	r, err := parseKeyFile(data, *activeKeyIDFlag)
+	if err != nil {
+		return err
+	}
+	ring.Store(r)
+	logrus.Infof("Loaded %d AI token master keys, active key %q", len(r.keys), r.activeID)
+	return nil
+}
+
+func parseKeyFile(data []byte, activeID string) (*keyring, error) {
+	r := &keyring{keys: map[string]cipher.AEAD{}}
+	var lastID string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("key file line %d: expected <id>:<base64 key>", line)
+		}
+		if _, ok = r.keys[id]; ok {
+			return nil, fmt.Errorf("key file line %d: duplicate key id %q", line, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("key file line %d: key must be %d base64 encoded bytes", line, keySize)
+		}
+		if r.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		lastID = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if activeID == "" {
+		activeID = lastID
+	}
+	if _, ok := r.keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q is not in the key file", activeID)
+	}
+	r.activeID = activeID
+	return r, nil
+}
+
+func currentRing() (*keyring, error) {
+	r := ring.Load()
+	if r == nil {
+		return nil, errors.New("AI token keys are not loaded")
+	}
+	return r, nil
+}
+
+// Enabled reports whether master keys are loaded. Secrets stored before
+// still need the keys to be decrypted.
+func Enabled() bool {
+	return ring.Load() != nil
+}
+
+// ActiveKeyID returns the master key new secrets are wrapped with.
+func ActiveKeyID() string {
+	if r := ring.Load(); r != nil {
+		return r.activeID
+	}
+	return ""
+}
+
+// Encrypt seals plaintext under a fresh data key. The associated data is not
+// stored but has to be passed to Decrypt again, which ties a secret to its
+// owner so it cannot be copied to another row.
+func Encrypt(plaintext, associatedData string) (Sealed, error) {
+	r, err := currentRing()
+	if err != nil {
+		return Sealed{}, err
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return Sealed{}, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return Sealed{}, err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), []byte(associatedData))
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := seal(r.keys[r.activeID], dataKey, []byte(r.activeID))
+	if err != nil {
+		return Sealed{}, err
+	}
+
+	return Sealed{
+		KeyID:      r.activeID,
+		DataKey:    base64.StdEncoding.EncodeToString(wrapped),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// Decrypt opens a secret sealed by Encrypt with the same associated data.
+func Decrypt(sealed Sealed, associatedData string) (string, error) {
+	r, err := currentRing()
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := r.unwrap(sealed)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext, []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap wraps the data key of a secret with the active master key. The
+// ciphertext stays the same.
+func Rewrap(sealed Sealed) (Sealed, error) {
+	r, err := currentRing()
+	if err != nil {
+		return Sealed{}, err
+	}
+	if sealed.KeyID == r.activeID {
+		return sealed, nil
+	}
+	dataKey, err := r.unwrap(sealed)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := seal(r.keys[r.activeID], dataKey, []byte(r.activeID))
+	if err != nil {
+		return Sealed{}, err
+	}
+
+	sealed.KeyID = r.activeID
+	sealed.DataKey = base64.StdEncoding.EncodeToString(wrapped)
+	return sealed, nil
+}
+
+func (r *keyring) unwrap(sealed Sealed) ([]byte, error) {
+	master, ok := r.keys[sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, sealed.KeyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(sealed.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(master, wrapped, []byte(sealed.KeyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the random nonce followed by the ciphertext.
+func seal(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(aead cipher.AEAD, data, associatedData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, associatedData)
+}
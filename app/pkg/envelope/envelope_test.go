@@ -0,0 +1,217 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newKeyLine(t *testing.T, id string) string {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key) + "\n"
+}
+
+// useKeys installs a keyring parsed from the key file lines, as LoadKeys
+// would, and restores the previous one when the test ends.
+func useKeys(t *testing.T, activeID string, lines ...string) {
+	t.Helper()
+
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line...)
+	}
+	r, err := parseKeyFile(data, activeID)
+	if err != nil {
+		t.Fatalf("parseKeyFile: %v", err)
+	}
+	previous := ring.Swap(r)
+	t.Cleanup(func() { ring.Store(previous) })
+}
+
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("set %s: %v", name, err)
+	}
+	t.Cleanup(func() { _ = flag.Set(name, previous) })
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	useKeys(t, "", newKeyLine(t, "k1"))
+
+	sealed, err := Encrypt("sk-secret-token", "user_ai_settings:1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if sealed.KeyID != "k1" {
+		t.Errorf("key id = %q, want k1", sealed.KeyID)
+	}
+	if sealed.Ciphertext == "" || sealed.DataKey == "" {
+		t.Fatalf("sealed = %+v", sealed)
+	}
+
+	plaintext, err := Decrypt(sealed, "user_ai_settings:1")
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if plaintext != "sk-secret-token" {
+		t.Errorf("plaintext = %q", plaintext)
+	}
+
+	again, err := Encrypt("sk-secret-token", "user_ai_settings:1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if again.Ciphertext == sealed.Ciphertext || again.DataKey == sealed.DataKey {
+		t.Error("the same secret was sealed twice with the same data key")
+	}
+}
+
+func TestDecryptRejectsOtherAssociatedData(t *testing.T) {
+	useKeys(t, "", newKeyLine(t, "k1"))
+
+	sealed, err := Encrypt("sk-secret-token", "user_ai_settings:1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err = Decrypt(sealed, "user_ai_settings:2"); err == nil {
+		t.Fatal("a secret copied to another row was decrypted")
+	}
+}
+
+func TestDecryptRejectsTamperedKeyID(t *testing.T) {
+	useKeys(t, "k1", newKeyLine(t, "k1"), newKeyLine(t, "k2"))
+
+	sealed, err := Encrypt("sk-secret-token", "ad")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	sealed.KeyID = "k2"
+	if _, err = Decrypt(sealed, "ad"); err == nil {
+		t.Fatal("a data key was unwrapped with another master key")
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	useKeys(t, "", newKeyLine(t, "k1"))
+
+	sealed, err := Encrypt("sk-secret-token", "ad")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	useKeys(t, "", newKeyLine(t, "k2"))
+
+	if _, err = Decrypt(sealed, "ad"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt: err = %v, want ErrUnknownKey", err)
+	}
+	if _, err = Rewrap(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Rewrap: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	k1, k2 := newKeyLine(t, "k1"), newKeyLine(t, "k2")
+	useKeys(t, "", k1)
+
+	sealed, err := Encrypt("sk-secret-token", "ad")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// k2 becomes active; secrets wrapped with k1 still open.
+	useKeys(t, "", k1, k2)
+	if ActiveKeyID() != "k2" {
+		t.Fatalf("active key = %q, want k2", ActiveKeyID())
+	}
+	if plaintext, err := Decrypt(sealed, "ad"); err != nil || plaintext != "sk-secret-token" {
+		t.Fatalf("Decrypt after rotation = %q, %v", plaintext, err)
+	}
+
+	rewrapped, err := Rewrap(sealed)
+	if err != nil {
+		t.Fatalf("Rewrap: %v", err)
+	}
+	if rewrapped.KeyID != "k2" {
+		t.Errorf("rewrapped key id = %q, want k2", rewrapped.KeyID)
+	}
+	if rewrapped.Ciphertext != sealed.Ciphertext {
+		t.Error("Rewrap changed the ciphertext")
+	}
+	if again, err := Rewrap(rewrapped); err != nil || again != rewrapped {
+		t.Errorf("Rewrap of a secret under the active key = %+v, %v", again, err)
+	}
+
+	// k1 is retired once every secret is rewrapped.
+	useKeys(t, "", k2)
+	if plaintext, err := Decrypt(rewrapped, "ad"); err != nil || plaintext != "sk-secret-token" {
+		t.Fatalf("Decrypt after retiring k1 = %q, %v", plaintext, err)
+	}
+}
+
+func TestParseKeyFileErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"no separator":   "k1\n",
+		"empty id":       ":" + base64.StdEncoding.EncodeToString(make([]byte, keySize)) + "\n",
+		"short key":      "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\n",
+		"not base64":     "k1:not-base64!\n",
+		"duplicate id":   newKeyLine(t, "k1") + newKeyLine(t, "k1"),
+		"no keys":        "# comment only\n",
+		"unknown active": newKeyLine(t, "k1"),
+	} {
+		activeID := ""
+		if name == "unknown active" {
+			activeID = "k9"
+		}
+		if _, err := parseKeyFile([]byte(data), activeID); err == nil {
+			t.Errorf("%s: key file was accepted", name)
+		}
+	}
+}
+
+func TestLoadKeysRequiresKeyFile(t *testing.T) {
+	previous := ring.Swap(nil)
+	t.Cleanup(func() { ring.Store(previous) })
+	setFlag(t, "aiTokenKeyFile", "")
+
+	if err := LoadKeys(); !errors.Is(err, ErrNoKeyFile) {
+		t.Fatalf("LoadKeys without a key file: err = %v, want ErrNoKeyFile", err)
+	}
+
+	setFlag(t, "allowPlaintextAITokens", "true")
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys with plaintext allowed: %v", err)
+	}
+	if Enabled() {
+		t.Error("encryption is enabled without keys")
+	}
+}
+
+func TestLoadKeysFromFile(t *testing.T) {
+	previous := ring.Swap(nil)
+	t.Cleanup(func() { ring.Store(previous) })
+
+	path := filepath.Join(t.TempDir(), "ai_token_keys")
+	if err := os.WriteFile(path, []byte("# old\n"+newKeyLine(t, "k1")+newKeyLine(t, "k2")), 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	setFlag(t, "aiTokenKeyFile", path)
+	setFlag(t, "aiTokenActiveKeyID", "k1")
+
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if !Enabled() || ActiveKeyID() != "k1" {
+		t.Errorf("enabled = %v, active key = %q, want k1", Enabled(), ActiveKeyID())
+	}
+}
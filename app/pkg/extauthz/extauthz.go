@@ -0,0 +1,112 @@
+package extauthz
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"net"
+	"strconv"
+)
+
+const (
+	headerUserID    = "x-user-id"
+	headerUserEmail = "x-user-email"
+	headerUserScope = "x-user-scope"
+)
+
+var (
+	extAuthzPort = flag.String("extAuthzPort", "", "Port for the Envoy ext_authz gRPC server, empty disables it")
+)
+
+// server implements envoy.service.auth.v3.Authorization. It accepts the same
+// tokens as JWTMiddleware and passes the user identity to the upstream in
+// request headers, overwriting whatever the client sent in them.
+type server struct {
+	authv3.UnimplementedAuthorizationServer
+}
+
+func NewServer() *grpc.Server {
+	s := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(s, &server{})
+	return s
+}
+
+// ListenAndServe starts the gRPC server when extAuthzPort is set.
+func ListenAndServe() error {
+	if *extAuthzPort == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", *extAuthzPort))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Envoy ext_authz server is running on %s...", *extAuthzPort)
+	return NewServer().Serve(lis)
+}
+
+func (s *server) Check(_ context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+
+	token, err := jwt.TokenFromHeaders(headers["authorization"], headers["cookie"])
+	if err != nil {
+		return denied(err), nil
+	}
+	claims, err := jwt.ParseToken(token)
+	if err != nil {
+		return denied(err), nil
+	}
+
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return denied(fmt.Errorf("invalid token claims")), nil
+	}
+	email, _ := claims["email"].(string)
+	scope, _ := claims["scope"].(string)
+
+	resp := &authv3.OkHttpResponse{
+		Headers: []*corev3.HeaderValueOption{
+			overwriteHeader(headerUserID, strconv.Itoa(int(userID))),
+			overwriteHeader(headerUserEmail, email),
+		},
+	}
+	if scope != "" {
+		resp.Headers = append(resp.Headers, overwriteHeader(headerUserScope, scope))
+	} else {
+		resp.HeadersToRemove = []string{headerUserScope}
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: resp},
+	}, nil
+}
+
+func denied(err error) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.Unauthenticated), Message: err.Error()},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+				Headers: []*corev3.HeaderValueOption{
+					overwriteHeader("content-type", "application/json"),
+				},
+				Body: `{"status":401,"message":"Unauthorized"}`,
+			},
+		},
+	}
+}
+
+func overwriteHeader(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
@@ -0,0 +1,245 @@
+package extauthz
+
+import (
+	"context"
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/revocation"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	gojwt "github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSecret = "extauthz-test-secret"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	for name, value := range map[string]string{
+		"secretKey":          testSecret,
+		"revocationStore":    "memory",
+		"revocationCacheTTL": "0",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			panic(err)
+		}
+	}
+	if err := jwt.LoadKeys(); err != nil {
+		panic(err)
+	}
+	jwt.SetRevocationChecker(func(jti string, userID int, issuedAt time.Time) (bool, error) {
+		return revocation.GetStore().IsRevoked(jti, userID, issuedAt)
+	})
+	os.Exit(m.Run())
+}
+
+// newClient serves NewServer on an in-memory listener and returns a client
+// connected to it, so the server is exercised over real gRPC without Envoy.
+func newClient(t *testing.T) authv3.AuthorizationClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer()
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func check(t *testing.T, client authv3.AuthorizationClient, headers map[string]string) *authv3.CheckResponse {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.Check(ctx, &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: headers},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	return resp
+}
+
+func signToken(t *testing.T, claims gojwt.MapClaims) (string, string) {
+	t.Helper()
+
+	jti := strconv.FormatInt(time.Now().UnixNano(), 36)
+	now := time.Now()
+	claims["jti"] = jti
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Minute).Unix()
+
+	token, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token, jti
+}
+
+func okHeaders(t *testing.T, resp *authv3.CheckResponse) (map[string]*corev3.HeaderValueOption, []string) {
+	t.Helper()
+
+	if code := codes.Code(resp.GetStatus().GetCode()); code != codes.OK {
+		t.Fatalf("status = %v (%s), want OK", code, resp.GetStatus().GetMessage())
+	}
+	ok := resp.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("response has no OkResponse: %v", resp)
+	}
+	headers := make(map[string]*corev3.HeaderValueOption)
+	for _, h := range ok.GetHeaders() {
+		headers[h.GetHeader().GetKey()] = h
+	}
+	return headers, ok.GetHeadersToRemove()
+}
+
+func assertHeader(t *testing.T, headers map[string]*corev3.HeaderValueOption, key, want string) {
+	t.Helper()
+
+	h, ok := headers[key]
+	if !ok {
+		t.Fatalf("header %s is not set", key)
+	}
+	if got := h.GetHeader().GetValue(); got != want {
+		t.Errorf("header %s = %q, want %q", key, got, want)
+	}
+	if h.GetAppendAction() != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+		t.Errorf("header %s append action = %v, want overwrite", key, h.GetAppendAction())
+	}
+}
+
+func assertDenied(t *testing.T, resp *authv3.CheckResponse) {
+	t.Helper()
+
+	if code := codes.Code(resp.GetStatus().GetCode()); code != codes.Unauthenticated {
+		t.Fatalf("status = %v, want Unauthenticated", code)
+	}
+	denied := resp.GetDeniedResponse()
+	if denied == nil {
+		t.Fatalf("response has no DeniedResponse: %v", resp)
+	}
+	if denied.GetStatus().GetCode() != typev3.StatusCode_Unauthorized {
+		t.Errorf("denied HTTP status = %v, want 401", denied.GetStatus().GetCode())
+	}
+}
+
+func TestCheckAllowsValidToken(t *testing.T) {
+	client := newClient(t)
+	token, err := jwt.GenerateJWT("user@example.com", 42)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+
+	resp := check(t, client, map[string]string{
+		"authorization": "Bearer " + token,
+		headerUserID:    "1",
+		headerUserEmail: "admin@example.com",
+		headerUserScope: "admin",
+	})
+
+	headers, removed := okHeaders(t, resp)
+	assertHeader(t, headers, headerUserID, "42")
+	assertHeader(t, headers, headerUserEmail, "user@example.com")
+	if _, ok := headers[headerUserScope]; ok {
+		t.Errorf("header %s is set for a token without scope", headerUserScope)
+	}
+	if len(removed) != 1 || removed[0] != headerUserScope {
+		t.Errorf("headers to remove = %v, want [%s]", removed, headerUserScope)
+	}
+}
+
+func TestCheckAllowsTokenFromCookie(t *testing.T) {
+	client := newClient(t)
+	token, err := jwt.GenerateJWT("user@example.com", 7)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+
+	resp := check(t, client, map[string]string{"cookie": "access_token=" + token})
+
+	headers, _ := okHeaders(t, resp)
+	assertHeader(t, headers, headerUserID, "7")
+}
+
+func TestCheckOverwritesScope(t *testing.T) {
+	client := newClient(t)
+	token, _ := signToken(t, gojwt.MapClaims{
+		"userID": 42,
+		"email":  "user@example.com",
+		"scope":  "read",
+	})
+
+	resp := check(t, client, map[string]string{
+		"authorization": "Bearer " + token,
+		headerUserScope: "admin",
+	})
+
+	headers, removed := okHeaders(t, resp)
+	assertHeader(t, headers, headerUserScope, "read")
+	if len(removed) != 0 {
+		t.Errorf("headers to remove = %v, want none", removed)
+	}
+}
+
+func TestCheckDeniesMissingToken(t *testing.T) {
+	client := newClient(t)
+
+	assertDenied(t, check(t, client, map[string]string{headerUserID: "42"}))
+}
+
+func TestCheckDeniesInvalidToken(t *testing.T) {
+	client := newClient(t)
+
+	assertDenied(t, check(t, client, map[string]string{"authorization": "Bearer not-a-token"}))
+}
+
+func TestCheckDeniesRevokedToken(t *testing.T) {
+	client := newClient(t)
+	token, jti := signToken(t, gojwt.MapClaims{
+		"userID": 42,
+		"email":  "user@example.com",
+	})
+	headers := map[string]string{"authorization": "Bearer " + token}
+
+	okHeaders(t, check(t, client, headers))
+
+	if err := revocation.GetStore().Revoke(jti, 42, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	assertDenied(t, check(t, client, headers))
+}
+
+func TestCheckDeniesChallengeToken(t *testing.T) {
+	client := newClient(t)
+	token, _, err := jwt.GenerateChallengeJWT("mfa", "user@example.com", 42, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateChallengeJWT: %v", err)
+	}
+
+	assertDenied(t, check(t, client, map[string]string{"authorization": "Bearer " + token}))
+}
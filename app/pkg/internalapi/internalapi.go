@@ -0,0 +1,91 @@
+// Package internalapi runs the listener for the service-to-service API,
+// separate from the public port so it can be kept off the internet.
+package internalapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"net"
+	"os"
+)
+
+var (
+	internalPort           = flag.String("internalPort", "", "Port for the internal service API, empty disables it")
+	internalTLSCertFile    = flag.String("internalTLSCertFile", "", "PEM certificate of the internal listener, required unless internalAllowPlaintext is set")
+	internalTLSKeyFile     = flag.String("internalTLSKeyFile", "", "PEM private key of the internal listener")
+	internalClientCAFile   = flag.String("internalClientCAFile", "", "PEM CA bundle that signs client certificates, enables mTLS on the internal listener")
+	internalAllowPlaintext = flag.Bool("internalAllowPlaintext", false, "Serve the internal API over plain HTTP without a certificate, for local development only")
+
+	ErrPlaintext = errors.New("internal API needs internalTLSCertFile and internalTLSKeyFile, set -internalAllowPlaintext to serve plain HTTP")
+)
+
+// ListenAndServe starts the internal listener when internalPort is set.
+func ListenAndServe(handler fasthttp.RequestHandler) error {
+	if *internalPort == "" {
+		return nil
+	}
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil && !*internalAllowPlaintext {
+		return ErrPlaintext
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", *internalPort))
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, tlsConfig)
+	} else {
+		logrus.Warnf("Internal API serves plain HTTP, only client tokens can authenticate")
+	}
+
+	server := &fasthttp.Server{
+		Handler: handler,
+		Name:    "user-service-internal",
+	}
+	logrus.Infof("Internal API is running on %s...", *internalPort)
+	return server.Serve(lis)
+}
+
+// loadTLSConfig returns nil when no certificate is configured. With a client
+// CA, certificates are verified when presented but not required, so clients
+// can still use a bearer token instead.
+func loadTLSConfig() (*tls.Config, error) {
+	if *internalTLSCertFile == "" {
+		if *internalClientCAFile != "" {
+			return nil, errors.New("internalClientCAFile needs internalTLSCertFile and internalTLSKeyFile")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(*internalTLSCertFile, *internalTLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if *internalClientCAFile != "" {
+		data, err := os.ReadFile(*internalClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", *internalClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
@@ -0,0 +1,47 @@
+package internalapi
+
+import (
+	"errors"
+	"flag"
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("set %s: %v", name, err)
+	}
+	t.Cleanup(func() { _ = flag.Set(name, previous) })
+}
+
+func TestListenAndServeRefusesPlainHTTP(t *testing.T) {
+	setFlag(t, "internalPort", "0")
+
+	err := ListenAndServe(func(ctx *fasthttp.RequestCtx) {
+		t.Error("request served over plain HTTP")
+	})
+	if !errors.Is(err, ErrPlaintext) {
+		t.Errorf("err = %v, want ErrPlaintext", err)
+	}
+}
+
+func TestListenAndServeNeedsCertificateForClientCA(t *testing.T) {
+	setFlag(t, "internalPort", "0")
+	setFlag(t, "internalAllowPlaintext", "true")
+	setFlag(t, "internalClientCAFile", "ca.crt")
+
+	if err := ListenAndServe(nil); err == nil {
+		t.Error("client CA without a server certificate was accepted")
+	}
+}
+
+func TestListenAndServeDisabled(t *testing.T) {
+	setFlag(t, "internalPort", "")
+
+	if err := ListenAndServe(nil); err != nil {
+		t.Errorf("disabled listener: %v", err)
+	}
+}
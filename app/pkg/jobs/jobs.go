@@ -0,0 +1,23 @@
+// Package jobs starts the maintenance work that runs next to the HTTP server.
+package jobs
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/sirupsen/logrus"
+)
+
+// Start runs the background jobs for as long as the process runs: purging
+// deleted accounts past their grace period and re-wrapping AI tokens after a
+// master key rotation.
+func Start() {
+	go service.PurgeDeletedAccounts()
+	go service.ReencryptAITokens()
+}
+
+// EncryptAITokens is the one-shot migration that encrypts AI tokens stored
+// before encryption at rest was introduced.
+func EncryptAITokens() error {
+	encrypted, err := service.EncryptExistingAITokens()
+	logrus.Infof("Encrypted %d AI tokens", encrypted)
+	return err
+}
@@ -0,0 +1,73 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns the public part of every key in the keyring, including
+// verification-only ones. Shared HS256 secrets are never published, so the
+// set is empty when only HS256 is configured.
+func PublicJWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	r := ring.Load()
+	if r == nil {
+		return set
+	}
+
+	ids := make([]string, 0, len(r.keys))
+	for id := range r.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if jwk, ok := toJWK(r.keys[id]); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func toJWK(k *key) (JWK, bool) {
+	switch pub := k.verifier.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: k.method.Alg(),
+			Kid: k.id,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: k.method.Alg(),
+			Kid: k.id,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, true
+	default:
+		return JWK{}, false
+	}
+}
@@ -1,53 +1,132 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"flag"
-	"fmt"
 	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
+	"math"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	secretKeyFlag = flag.String("secretKey", "", "secret key")
+	secretKeyFlag  = flag.String("secretKey", "", "secret key")
+	authCookieFlag = flag.String("authCookie", "access_token", "Cookie to read the access token from when there is no Authorization header, empty disables it")
+	accessTokenTTL = flag.Duration("accessTokenTTL", 15*time.Minute, "Lifetime of issued access tokens")
+	clientTokenTTL = flag.Duration("clientTokenTTL", 15*time.Minute, "Lifetime of access tokens issued to service clients")
 )
 
+const clientPurpose = "client_credentials"
+
+// RevocationChecker reports whether a token of the user with the given jti
+// and issue time was revoked.
+type RevocationChecker func(jti string, userID int, issuedAt time.Time) (bool, error)
+
+var revocationChecker atomic.Pointer[RevocationChecker]
+
+// SetRevocationChecker installs the revocation check of JWTMiddleware and
+// ParseToken. It must be called before any token is verified; until then
+// every user token is rejected.
+func SetRevocationChecker(checker RevocationChecker) {
+	revocationChecker.Store(&checker)
+}
+
+func AccessTokenTTL() time.Duration {
+	return *accessTokenTTL
+}
+
 func GenerateJWT(email string, id int) (string, error) {
-	claims := jwt.MapClaims{
+	token, _, err := signToken(jwt.MapClaims{
 		"userID": id,
 		"email":  email,
-		"exp":    time.Now().Add(time.Hour * 24 * 30).Unix(),
+	}, *accessTokenTTL)
+	return token, err
+}
+
+// GenerateChallengeJWT issues a short-lived token that only proves one step of
+// a multi-step flow, such as a correct password before the second factor.
+// Such tokens are rejected by JWTMiddleware and accepted only by
+// ParseChallengeToken with the same purpose. The jti is returned alongside the
+// token for callers that track single use.
+func GenerateChallengeJWT(purpose, email string, id int, ttl time.Duration) (string, string, error) {
+	return signToken(jwt.MapClaims{
+		"userID":  id,
+		"email":   email,
+		"purpose": purpose,
+	}, ttl)
+}
+
+// ClientTokenTTL is the lifetime of tokens issued by GenerateClientJWT.
+func ClientTokenTTL() time.Duration {
+	return *clientTokenTTL
+}
+
+// GenerateClientJWT issues an access token for a service client from the
+// client credentials grant. It carries no user, is rejected by JWTMiddleware
+// and is only accepted by ParseClientToken. Client tokens cannot be revoked,
+// so they are kept short-lived.
+func GenerateClientJWT(clientID, scope string) (string, error) {
+	token, _, err := signToken(jwt.MapClaims{
+		"sub":       clientID,
+		"client_id": clientID,
+		"scope":     scope,
+		"purpose":   clientPurpose,
+	}, *clientTokenTTL)
+	return token, err
+}
+
+func signToken(claims jwt.MapClaims, ttl time.Duration) (string, string, error) {
+	jti, err := newJTI()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims["jti"] = jti
+	// iat has millisecond precision so that a logout-all cut-off does not
+	// catch tokens issued right after it in the same second.
+	claims["iat"] = float64(now.UnixMilli()) / 1000
+	claims["exp"] = now.Add(ttl).Unix()
+
+	signingKey, err := activeKey()
+	if err != nil {
+		logrus.Errorf("Cannot get JWT signing key: %v", err)
+		return "", "", err
 	}
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	if signingKey.id != "" {
+		token.Header["kid"] = signingKey.id
+	}
+	signed, err := token.SignedString(signingKey.signer)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, jti, nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	if secretKeyFlag == nil && *secretKeyFlag == "" {
-		logrus.Errorf("secretKeyFlag is nil")
-		return "", errors.New("secret key is required")
+func newJTI() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
 	}
-	return token.SignedString([]byte(*secretKeyFlag))
+	return base64.RawURLEncoding.EncodeToString(buf), nil
 }
 
 func JWTMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		authHeader := string(ctx.Request.Header.Peek("Authorization"))
-		if authHeader == "" {
-			respJSON.WriteJSONError(ctx, http.StatusUnauthorized, fmt.Errorf("Unauthorized: "), "Missing token")
-			return
-		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			respJSON.WriteJSONError(ctx, http.StatusUnauthorized, fmt.Errorf("Unauthorized: "), "Invalid token")
+		tokenStr, err := TokenFromRequest(ctx)
+		if err != nil {
+			respJSON.WriteJSONError(ctx, http.StatusUnauthorized, err, "Unauthorized")
 			return
 		}
 
-		tokenStr := parts[1]
-
 		claims, err := parseJWT(tokenStr)
 		if err != nil {
 			respJSON.WriteJSONError(ctx, http.StatusUnauthorized, err, "error")
@@ -56,21 +135,122 @@ func JWTMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 
 		ctx.SetUserValue("userID", claims["userID"])
 		ctx.SetUserValue("email", claims["email"])
+		ctx.SetUserValue("jti", claims["jti"])
+		ctx.SetUserValue("exp", claims["exp"])
+		ctx.SetUserValue("scope", claims["scope"])
 
 		next(ctx)
 	}
 }
 
+// TokenFromRequest returns the bearer token from the Authorization header or,
+// when there is no header, from the auth cookie.
+func TokenFromRequest(ctx *fasthttp.RequestCtx) (string, error) {
+	return TokenFromHeaders(string(ctx.Request.Header.Peek("Authorization")), string(ctx.Request.Header.Peek("Cookie")))
+}
+
+// TokenFromHeaders is TokenFromRequest for raw Authorization and Cookie
+// header values, for callers that do not speak fasthttp.
+func TokenFromHeaders(authHeader, cookieHeader string) (string, error) {
+	if authHeader == "" {
+		if token := cookieValue(cookieHeader, *authCookieFlag); token != "" {
+			return token, nil
+		}
+		return "", errors.New("missing token")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", errors.New("invalid token")
+	}
+	return parts[1], nil
+}
+
+func cookieValue(cookieHeader, name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, cookie := range strings.Split(cookieHeader, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(cookie), "=")
+		if ok && key == name {
+			return value
+		}
+	}
+	return ""
+}
+
+// ParseToken verifies a token the same way JWTMiddleware does, including the
+// revocation check, and returns its claims.
+func ParseToken(tokenStr string) (jwt.MapClaims, error) {
+	return parseJWT(tokenStr)
+}
+
+// ParseChallengeToken verifies a token issued by GenerateChallengeJWT for
+// the given purpose.
+func ParseChallengeToken(tokenStr, purpose string) (jwt.MapClaims, error) {
+	claims, err := verifyJWT(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return nil, errors.New("invalid token purpose")
+	}
+	return claims, nil
+}
+
+// ParseClientToken verifies a token issued by GenerateClientJWT.
+func ParseClientToken(tokenStr string) (jwt.MapClaims, error) {
+	claims, err := verifySignedJWT(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if p, _ := claims["purpose"].(string); p != clientPurpose {
+		return nil, errors.New("invalid token purpose")
+	}
+	return claims, nil
+}
+
 func parseJWT(tokenStr string) (jwt.MapClaims, error) {
+	claims, err := verifyJWT(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["purpose"]; ok {
+		return nil, errors.New("invalid token purpose")
+	}
+	return claims, nil
+}
+
+func verifyJWT(tokenStr string) (jwt.MapClaims, error) {
+	claims, err := verifySignedJWT(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRevoked(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignedJWT checks the signature and expiry, but not revocation.
+func verifySignedJWT(tokenStr string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		k, err := verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != k.method.Alg() {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(*secretKeyFlag), nil
+		return k.verifier, nil
 	})
 
-	if err != nil || !token.Valid {
-		return nil, errors.New(fmt.Sprintf("%s; is valid:%t.", err, token.Valid))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
@@ -85,3 +265,27 @@ func parseJWT(tokenStr string) (jwt.MapClaims, error) {
 
 	return claims, nil
 }
+
+func checkRevoked(claims jwt.MapClaims) error {
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return errors.New("invalid token claims")
+	}
+	jti, _ := claims["jti"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+
+	checker := revocationChecker.Load()
+	if checker == nil {
+		logrus.Error("Cannot check token revocation: no revocation checker is set")
+		return errors.New("cannot verify token")
+	}
+	revoked, err := (*checker)(jti, int(userID), time.UnixMilli(int64(math.Round(issuedAt*1000))))
+	if err != nil {
+		logrus.Errorf("Cannot check token revocation: %v", err)
+		return errors.New("cannot verify token")
+	}
+	if revoked {
+		return errors.New("token revoked")
+	}
+	return nil
+}
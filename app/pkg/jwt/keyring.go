@@ -0,0 +1,204 @@
+package jwt
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Keys are rotated in stages without logging anybody out:
+//  1. add the new key as verification-only and reload, so it is published in
+//     the JWKS before any token is signed with it;
+//  2. make it the active key and reload;
+//  3. drop the old key once the last token signed with it has expired.
+
+var (
+	keysDirFlag          = flag.String("jwtKeysDir", "", "Directory with JWT keys: <kid>.pem for RS256/EdDSA keys, <kid>.secret for HS256 secrets")
+	activeKeyIDFlag      = flag.String("jwtActiveKeyID", "", "kid of the signing key in jwtKeysDir")
+	verificationKeyFiles = flag.String("jwtVerificationKeyFiles", "", "Comma separated PEM files with verification-only keys, used when jwtKeysDir is empty")
+	legacyHS256Flag      = flag.String("jwtLegacyHS256", "auto", "Verify tokens without a kid with secretKey: true, false, or auto to do it only while the active key is HS256")
+
+	ring atomic.Pointer[keyring]
+)
+
+// keyring holds one active signing key and any number of keys that are only
+// used to verify tokens issued before a rotation.
+type keyring struct {
+	active *key
+	keys   map[string]*key
+}
+
+func (r *keyring) add(k *key) error {
+	if _, ok := r.keys[k.id]; ok {
+		return fmt.Errorf("duplicate JWT key id %q", k.id)
+	}
+	r.keys[k.id] = k
+	return nil
+}
+
+// LoadKeys (re)loads the keyring. It must be called after the flags are parsed
+// and before any token is issued or verified. On error the previously loaded
+// keyring stays in use.
+func LoadKeys() error {
+	var (
+		r   *keyring
+		err error
+	)
+	if *keysDirFlag != "" {
+		r, err = keyringFromDir(*keysDirFlag, *activeKeyIDFlag)
+	} else {
+		r, err = keyringFromFlags()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Tokens issued before kids were introduced are verified with secretKey.
+	// With an asymmetric active key this stays off unless asked for, as
+	// anybody holding the shared secret could keep minting accepted tokens.
+	legacy, err := acceptLegacyHS256(r.active)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.keys[""]; !ok && legacy && *secretKeyFlag != "" {
+		r.keys[""] = newHMACKey("", []byte(*secretKeyFlag))
+	}
+
+	ring.Store(r)
+	logrus.Infof("Loaded %d JWT keys, active key %q", len(r.keys), r.active.id)
+	return nil
+}
+
+func acceptLegacyHS256(active *key) (bool, error) {
+	switch *legacyHS256Flag {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "auto":
+		return active.method.Alg() == AlgHS256, nil
+	default:
+		return false, fmt.Errorf("jwtLegacyHS256 must be true, false or auto, got %q", *legacyHS256Flag)
+	}
+}
+
+func keyringFromFlags() (*keyring, error) {
+	active, err := keyFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	r := &keyring{active: active, keys: map[string]*key{}}
+	if err = r.add(active); err != nil {
+		return nil, err
+	}
+
+	for _, path := range strings.Split(*verificationKeyFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		k, err := parseKeyPEM("", data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		k.signer = nil
+		if err = r.add(k); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func keyringFromDir(dir, activeID string) (*keyring, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &keyring{keys: map[string]*key{}}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if ext != ".pem" && ext != ".secret" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(name, ext)
+
+		var k *key
+		if ext == ".secret" {
+			secret := strings.TrimSpace(string(data))
+			if secret == "" {
+				return nil, fmt.Errorf("%s: empty secret", name)
+			}
+			k = newHMACKey(id, []byte(secret))
+		} else if k, err = parseKeyPEM(id, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err = r.add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	active, ok := r.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active JWT key %q not found in %s", activeID, dir)
+	}
+	if !active.canSign() {
+		return nil, fmt.Errorf("active JWT key %q has no private key", activeID)
+	}
+	r.active = active
+	return r, nil
+}
+
+func activeKey() (*key, error) {
+	r := ring.Load()
+	if r == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	return r.active, nil
+}
+
+func verificationKey(id string) (*key, error) {
+	r := ring.Load()
+	if r == nil {
+		return nil, errors.New("JWT keys are not loaded")
+	}
+	k, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	return k, nil
+}
+
+// ReloadKeysOnSIGHUP reloads the keyring every time the process gets SIGHUP.
+func ReloadKeysOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			if err := LoadKeys(); err != nil {
+				logrus.Errorf("Failed to reload JWT keys, keeping the previous ones: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package jwt
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func mustGenerate(t *testing.T) string {
+	t.Helper()
+
+	token, err := GenerateJWT("user@example.com", 42)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	return token
+}
+
+func TestUnknownKidRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "k1.pem", newEd25519PEM(t))
+	loadKeys(t, map[string]string{"jwtKeysDir": dir, "jwtActiveKeyID": "k1", "jwtLegacyHS256": "auto"})
+
+	other := newRSAKey(t)
+	assertRejected(t, sign(t, jwt.SigningMethodRS256, other, "k9"))
+	// A known kid with a signature by another key.
+	assertRejected(t, sign(t, jwt.SigningMethodRS256, other, "k1"))
+	// No kid falls back to the legacy secret, which is off here.
+	assertRejected(t, sign(t, jwt.SigningMethodRS256, other, ""))
+}
+
+func TestKeysDir(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "k1.pem", newEd25519PEM(t))
+	writeKeyFile(t, dir, "hs.secret", []byte("shared-secret\n"))
+	writeKeyFile(t, dir, "README", []byte("not a key"))
+	loadKeys(t, map[string]string{"jwtKeysDir": dir, "jwtActiveKeyID": "k1"})
+
+	if kid := tokenKid(t, mustGenerate(t)); kid != "k1" {
+		t.Errorf("kid = %q, want k1", kid)
+	}
+	assertAccepted(t, sign(t, jwt.SigningMethodHS256, []byte("shared-secret"), "hs"))
+
+	jwks := PublicJWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "k1" || jwks.Keys[0].Alg != AlgEdDSA {
+		t.Errorf("JWKS = %+v, want only k1 without the shared secret", jwks.Keys)
+	}
+
+	for name, setup := range map[string]func(dir string){
+		"missing active key": func(dir string) {
+			writeKeyFile(t, dir, "k1.pem", newEd25519PEM(t))
+		},
+		"public active key": func(dir string) {
+			writeKeyFile(t, dir, "k2.pem", publicPEM(t, &newRSAKey(t).PublicKey))
+		},
+		"empty secret": func(dir string) {
+			writeKeyFile(t, dir, "k2.secret", []byte("\n"))
+		},
+		"garbage key": func(dir string) {
+			writeKeyFile(t, dir, "k2.pem", []byte("-----BEGIN NOTHING-----"))
+		},
+	} {
+		bad := t.TempDir()
+		setup(bad)
+		setFlag(t, "jwtKeysDir", bad)
+		setFlag(t, "jwtActiveKeyID", "k2")
+		if err := LoadKeys(); err == nil {
+			t.Errorf("%s: LoadKeys succeeded", name)
+		}
+	}
+}
+
+func waitForActiveKey(t *testing.T, id string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if k, err := activeKey(); err == nil && k.id == id {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("active key did not become %q", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReloadOnSIGHUP walks through a key rotation: a new key is added and made
+// active with SIGHUP, tokens issued under the old key stay valid until the old
+// key is removed, and a broken reload keeps the keys in use.
+func TestReloadOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "k1.pem", newEd25519PEM(t))
+	loadKeys(t, map[string]string{"jwtKeysDir": dir, "jwtActiveKeyID": "k1"})
+
+	old := mustGenerate(t)
+
+	// The flag is set before the handler starts, which is all the ordering
+	// the race detector can see between the two.
+	writeKeyFile(t, dir, "k2.pem", newEd25519PEM(t))
+	setFlag(t, "jwtActiveKeyID", "k2")
+	ReloadKeysOnSIGHUP()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("send SIGHUP: %v", err)
+	}
+	waitForActiveKey(t, "k2")
+
+	current := mustGenerate(t)
+	if kid := tokenKid(t, current); kid != "k2" {
+		t.Errorf("kid after rotation = %q, want k2", kid)
+	}
+	assertAccepted(t, old)
+	assertAccepted(t, current)
+
+	// A reload that fails leaves the keyring as it was.
+	setFlag(t, "jwtActiveKeyID", "k3")
+	if err := LoadKeys(); err == nil {
+		t.Fatal("LoadKeys succeeded without the active key")
+	}
+	waitForActiveKey(t, "k2")
+	assertAccepted(t, old)
+
+	// Once the old key is dropped its tokens are rejected.
+	if err := os.Remove(filepath.Join(dir, "k1.pem")); err != nil {
+		t.Fatalf("remove k1: %v", err)
+	}
+	writeKeyFile(t, dir, "k3.pem", newEd25519PEM(t))
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if _, err := ParseToken(old); err == nil || !strings.Contains(err.Error(), "unknown key id") {
+		t.Errorf("token of a removed key: err = %v, want unknown key id", err)
+	}
+	assertAccepted(t, current)
+}
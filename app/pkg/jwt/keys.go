@@ -0,0 +1,128 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	signingAlgFlag     = flag.String("jwtSigningAlg", AlgHS256, "JWT signing algorithm: HS256, RS256 or EdDSA")
+	privateKeyFileFlag = flag.String("jwtPrivateKeyFile", "", "PEM file with the RS256 or EdDSA signing key")
+	keyIDFlag          = flag.String("jwtKeyID", "", "kid header of issued tokens, derived from the public key when empty")
+)
+
+// key is a single JWT key. For HS256 both signer and verifier are the shared
+// secret; asymmetric keys without a private part can only verify tokens.
+type key struct {
+	id       string
+	method   jwt.SigningMethod
+	signer   crypto.PrivateKey
+	verifier crypto.PublicKey
+}
+
+func (k *key) canSign() bool {
+	return k.signer != nil
+}
+
+func keyFromFlags() (*key, error) {
+	switch *signingAlgFlag {
+	case AlgHS256:
+		if *secretKeyFlag == "" {
+			return nil, errors.New("secret key is required for HS256")
+		}
+		return newHMACKey(*keyIDFlag, []byte(*secretKeyFlag)), nil
+	case AlgRS256, AlgEdDSA:
+		if *privateKeyFileFlag == "" {
+			return nil, fmt.Errorf("private key file is required for %s", *signingAlgFlag)
+		}
+		data, err := os.ReadFile(*privateKeyFileFlag)
+		if err != nil {
+			return nil, err
+		}
+		k, err := parsePrivateKeyPEM(*keyIDFlag, data)
+		if err != nil {
+			return nil, err
+		}
+		if k.method.Alg() != *signingAlgFlag {
+			return nil, fmt.Errorf("key in %s is not a %s key", *privateKeyFileFlag, *signingAlgFlag)
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", *signingAlgFlag)
+	}
+}
+
+func newHMACKey(id string, secret []byte) *key {
+	return &key{
+		id:       id,
+		method:   jwt.SigningMethodHS256,
+		signer:   secret,
+		verifier: secret,
+	}
+}
+
+func parsePrivateKeyPEM(id string, data []byte) (*key, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return newAsymmetricKey(id, jwt.SigningMethodRS256, rsaKey, &rsaKey.PublicKey)
+	}
+	if edKey, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		return newAsymmetricKey(id, jwt.SigningMethodEdDSA, edKey, edKey.(ed25519.PrivateKey).Public())
+	}
+	return nil, errors.New("key must be a PEM encoded RSA or Ed25519 private key")
+}
+
+func parsePublicKeyPEM(id string, data []byte) (*key, error) {
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return newAsymmetricKey(id, jwt.SigningMethodRS256, nil, rsaKey)
+	}
+	if edKey, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return newAsymmetricKey(id, jwt.SigningMethodEdDSA, nil, edKey)
+	}
+	return nil, errors.New("key must be a PEM encoded RSA or Ed25519 public key")
+}
+
+// parseKeyPEM accepts either a private key or a verification-only public key.
+func parseKeyPEM(id string, data []byte) (*key, error) {
+	if k, err := parsePrivateKeyPEM(id, data); err == nil {
+		return k, nil
+	}
+	if k, err := parsePublicKeyPEM(id, data); err == nil {
+		return k, nil
+	}
+	return nil, errors.New("key must be a PEM encoded RSA or Ed25519 key")
+}
+
+func newAsymmetricKey(id string, method jwt.SigningMethod, signer crypto.PrivateKey, verifier crypto.PublicKey) (*key, error) {
+	if id == "" {
+		der, err := x509.MarshalPKIXPublicKey(verifier)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		id = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+	if pub, ok := verifier.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key %s is shorter than 2048 bits", id)
+	}
+	return &key{
+		id:       id,
+		method:   method,
+		signer:   signer,
+		verifier: verifier,
+	}, nil
+}
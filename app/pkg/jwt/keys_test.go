@@ -0,0 +1,218 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testSecret = "jwt-test-secret"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if err := flag.Set("secretKey", testSecret); err != nil {
+		panic(err)
+	}
+	SetRevocationChecker(func(string, int, time.Time) (bool, error) {
+		return false, nil
+	})
+	os.Exit(m.Run())
+}
+
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("set %s: %v", name, err)
+	}
+	t.Cleanup(func() { _ = flag.Set(name, previous) })
+}
+
+// loadKeys loads the keyring with the flags set and restores the previous
+// keyring when the test ends.
+func loadKeys(t *testing.T, flags map[string]string) {
+	t.Helper()
+
+	for name, value := range flags {
+		setFlag(t, name, value)
+	}
+	previous := ring.Load()
+	t.Cleanup(func() { ring.Store(previous) })
+	if err := LoadKeys(); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return k
+}
+
+func privatePEM(t *testing.T, k interface{}) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		t.Fatalf("marshal private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicPEM(t *testing.T, k interface{}) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(k)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func newEd25519PEM(t *testing.T) []byte {
+	t.Helper()
+
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+	return privatePEM(t, k)
+}
+
+func writeKeyFile(t *testing.T, dir, name string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+// sign signs user claims with an arbitrary method, key and kid, to forge
+// tokens the service would never issue.
+func sign(t *testing.T, method jwt.SigningMethod, signingKey interface{}, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"userID": 42,
+		"email":  "user@example.com",
+		"jti":    "forged",
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(time.Minute).Unix(),
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(signingKey)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func assertAccepted(t *testing.T, token string) {
+	t.Helper()
+
+	if _, err := ParseToken(token); err != nil {
+		t.Errorf("token was rejected: %v", err)
+	}
+}
+
+func assertRejected(t *testing.T, token string) {
+	t.Helper()
+
+	if _, err := ParseToken(token); err == nil {
+		t.Error("token was accepted")
+	}
+}
+
+func TestIssuedTokensVerify(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "rs1.pem", privatePEM(t, rsaKey))
+	loadKeys(t, map[string]string{"jwtKeysDir": dir, "jwtActiveKeyID": "rs1"})
+
+	token, err := GenerateJWT("user@example.com", 42)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims["userID"] != float64(42) || claims["email"] != "user@example.com" {
+		t.Errorf("claims = %v", claims)
+	}
+
+	assertAccepted(t, sign(t, jwt.SigningMethodRS256, rsaKey, "rs1"))
+}
+
+// An HS256 token whose secret is the RSA public key must not verify: the
+// public key is published, so anybody could mint tokens.
+func TestAlgorithmConfusionRejected(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "rs1.pem", privatePEM(t, rsaKey))
+	loadKeys(t, map[string]string{"jwtKeysDir": dir, "jwtActiveKeyID": "rs1"})
+
+	pub := publicPEM(t, &rsaKey.PublicKey)
+	assertRejected(t, sign(t, jwt.SigningMethodHS256, pub, "rs1"))
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	assertRejected(t, sign(t, jwt.SigningMethodHS256, der, "rs1"))
+	assertRejected(t, sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "rs1"))
+	assertRejected(t, sign(t, jwt.SigningMethodPS256, rsaKey, "rs1"))
+}
+
+func TestLegacyHS256(t *testing.T) {
+	legacy := func(t *testing.T) string {
+		return sign(t, jwt.SigningMethodHS256, []byte(testSecret), "")
+	}
+	rsaDir := t.TempDir()
+	writeKeyFile(t, rsaDir, "rs1.pem", privatePEM(t, newRSAKey(t)))
+
+	t.Run("auto with an asymmetric active key", func(t *testing.T) {
+		loadKeys(t, map[string]string{"jwtKeysDir": rsaDir, "jwtActiveKeyID": "rs1", "jwtLegacyHS256": "auto"})
+		assertRejected(t, legacy(t))
+	})
+	t.Run("true with an asymmetric active key", func(t *testing.T) {
+		loadKeys(t, map[string]string{"jwtKeysDir": rsaDir, "jwtActiveKeyID": "rs1", "jwtLegacyHS256": "true"})
+		assertAccepted(t, legacy(t))
+	})
+	t.Run("auto with an HS256 active key", func(t *testing.T) {
+		loadKeys(t, map[string]string{"jwtKeysDir": "", "jwtSigningAlg": AlgHS256, "jwtKeyID": "hs1", "jwtLegacyHS256": "auto"})
+		assertAccepted(t, legacy(t))
+		assertAccepted(t, sign(t, jwt.SigningMethodHS256, []byte(testSecret), "hs1"))
+	})
+	t.Run("false with an HS256 active key", func(t *testing.T) {
+		loadKeys(t, map[string]string{"jwtKeysDir": "", "jwtSigningAlg": AlgHS256, "jwtKeyID": "hs1", "jwtLegacyHS256": "false"})
+		assertRejected(t, legacy(t))
+		assertAccepted(t, sign(t, jwt.SigningMethodHS256, []byte(testSecret), "hs1"))
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		loadKeys(t, map[string]string{"jwtKeysDir": rsaDir, "jwtActiveKeyID": "rs1", "jwtLegacyHS256": "auto"})
+		setFlag(t, "jwtLegacyHS256", "sometimes")
+		if err := LoadKeys(); err == nil {
+			t.Fatal("LoadKeys accepted an invalid jwtLegacyHS256")
+		}
+		// The keyring loaded before stays in use.
+		assertRejected(t, legacy(t))
+		if k, err := activeKey(); err != nil || k.id != "rs1" {
+			t.Errorf("active key = %v, %v, want rs1", k, err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const evictInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is back to burst and can be forgotten.
+	full time.Time
+}
+
+// memoryBackend keeps buckets in process memory, so every instance limits on
+// its own. Use the redis backend when running several instances.
+type memoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newMemoryBackend() *memoryBackend {
+	b := &memoryBackend{
+		buckets: make(map[string]*bucket),
+	}
+	go b.evictFull()
+	return b
+}
+
+func (b *memoryBackend) Take(key string, rate float64, burst int, now time.Time) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bk, ok := b.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(burst), updated: now}
+		b.buckets[key] = bk
+	}
+	bk.tokens = math.Min(float64(burst), bk.tokens+now.Sub(bk.updated).Seconds()*rate)
+	bk.updated = now
+
+	if bk.tokens < 1 {
+		return secondsToDuration((1 - bk.tokens) / rate), nil
+	}
+	bk.tokens--
+	bk.full = now.Add(secondsToDuration((float64(burst) - bk.tokens) / rate))
+	return 0, nil
+}
+
+func (b *memoryBackend) Refund(key string, burst int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if bk, ok := b.buckets[key]; ok {
+		bk.tokens = math.Min(float64(burst), bk.tokens+1)
+	}
+	return nil
+}
+
+func (b *memoryBackend) evictFull() {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		b.mu.Lock()
+		for key, bk := range b.buckets {
+			if now.After(bk.full) {
+				delete(b.buckets, key)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
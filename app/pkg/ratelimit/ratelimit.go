@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ByIP    = "ip"
+	ByEmail = "email"
+	// ByUser limits signed-in users by their user ID.
+	ByUser = "user"
+
+	// AnyRoute is the route of rules that apply to every request.
+	AnyRoute = "*"
+)
+
+// Backend keeps token buckets.
+type Backend interface {
+	// Take removes one token from the bucket under key, which holds up to
+	// burst tokens and refills at rate tokens per second. When the bucket is
+	// empty it returns how long until the next token is available.
+	Take(key string, rate float64, burst int, now time.Time) (time.Duration, error)
+	// Refund puts back a token taken by Take, up to burst.
+	Refund(key string, burst int) error
+}
+
+// Rule allows Limit requests per Period to one route for every distinct IP,
+// target email or signed-in user, with bursts of up to Limit.
+type Rule struct {
+	Route  string
+	By     string
+	Limit  int
+	Period time.Duration
+}
+
+func (r Rule) key(value string) string {
+	return "ratelimit:" + r.Route + ":" + r.By + ":" + value
+}
+
+var (
+	rateLimitRules = flag.String("rateLimitRules",
+		"*:ip:300/1m,"+
+			"/login:ip:30/1m,"+
+			"/register:ip:10/1h,/register:email:3/1h,"+
+			"/code:ip:10/1h,/code:email:3/1h,"+
+			"/request/reset/password:ip:10/1h,/request/reset/password:email:3/1h,"+
+			"/login/link:ip:10/1h,"+
+			"/email/change:ip:10/1h,/email/change:user:3/1h",
+		"Comma separated rate limits as route:ip|email|user:count/period, route * applies to every request")
+	rateLimitBackend = flag.String("rateLimitStore", "memory", "Rate limit backend: memory or redis")
+
+	rules   map[string][]Rule
+	backend Backend
+	once    sync.Once
+)
+
+func initBackend() {
+	switch *rateLimitBackend {
+	case "memory":
+		backend = newMemoryBackend()
+	case "redis":
+		backend = newRedisBackend()
+	default:
+		logrus.Fatalf("Unknown rate limit store: %s", *rateLimitBackend)
+	}
+}
+
+func GetBackend() Backend {
+	once.Do(func() {
+		initBackend()
+	})
+
+	return backend
+}
+
+// LoadRules parses the rateLimitRules flag. It must be called once after the
+// flags are parsed; until then no request is limited.
+func LoadRules() error {
+	parsed, err := parseRules(*rateLimitRules)
+	if err != nil {
+		return err
+	}
+	rules = parsed
+	return nil
+}
+
+// RulesFor returns the rules of the route followed by the rules of every route.
+func RulesFor(route string) []Rule {
+	matched := make([]Rule, 0, len(rules[route])+len(rules[AnyRoute]))
+	matched = append(matched, rules[route]...)
+	return append(matched, rules[AnyRoute]...)
+}
+
+// Allow takes a token for the value, an IP or an email, from the bucket of
+// the rule. It returns zero when the request may proceed and otherwise how
+// long the client should wait.
+func Allow(rule Rule, value string) (time.Duration, error) {
+	rate := float64(rule.Limit) / rule.Period.Seconds()
+	return GetBackend().Take(rule.key(value), rate, rule.Limit, time.Now())
+}
+
+// Refund returns the token Allow took for the value, for a request that was
+// rejected by a later rule and so never reached the handler.
+func Refund(rule Rule, value string) error {
+	return GetBackend().Refund(rule.key(value), rule.Limit)
+}
+
+func parseRules(value string) (map[string][]Rule, error) {
+	parsed := make(map[string][]Rule)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("rule %q is not route:by:count/period", entry)
+		}
+		if parts[1] != ByIP && parts[1] != ByEmail && parts[1] != ByUser {
+			return nil, fmt.Errorf("rule %q limits by unknown key %q", entry, parts[1])
+		}
+		count, period, ok := strings.Cut(parts[2], "/")
+		if !ok {
+			return nil, fmt.Errorf("rule %q is not route:by:count/period", entry)
+		}
+		limit, err := strconv.Atoi(count)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("rule %q has an invalid count", entry)
+		}
+		duration, err := time.ParseDuration(period)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("rule %q has an invalid period", entry)
+		}
+		parsed[parts[0]] = append(parsed[parts[0]], Rule{Route: parts[0], By: parts[1], Limit: limit, Period: duration})
+	}
+	return parsed, nil
+}
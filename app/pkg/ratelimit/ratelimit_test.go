@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// testBucket checks the token bucket math every backend has to implement:
+// bursts up to the limit, refill at the rate and the wait until the next
+// token.
+func testBucket(t *testing.T, b Backend) {
+	t.Helper()
+
+	const (
+		key   = "ratelimit:test:ip:192.0.2.1"
+		rate  = 2.0 // tokens per second
+		burst = 3
+	)
+	now := time.Unix(1700000000, 0)
+
+	take := func(at time.Time, want time.Duration) {
+		t.Helper()
+		wait, err := b.Take(key, rate, burst, at)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if wait != want {
+			t.Errorf("Take at +%s: wait = %s, want %s", at.Sub(now), wait, want)
+		}
+	}
+
+	for i := 0; i < burst; i++ {
+		take(now, 0)
+	}
+	take(now, 500*time.Millisecond)
+	take(now.Add(200*time.Millisecond), 300*time.Millisecond)
+	take(now.Add(500*time.Millisecond), 0)
+	take(now.Add(500*time.Millisecond), 500*time.Millisecond)
+
+	// A long pause refills the bucket only up to the burst.
+	later := now.Add(time.Hour)
+	for i := 0; i < burst; i++ {
+		take(later, 0)
+	}
+	take(later, 500*time.Millisecond)
+
+	if err := b.Refund(key, burst); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	take(later, 0)
+	take(later, 500*time.Millisecond)
+}
+
+func TestMemoryBucket(t *testing.T) {
+	testBucket(t, newMemoryBackend())
+}
+
+func TestRefundIsCappedAtBurst(t *testing.T) {
+	b := newMemoryBackend()
+	now := time.Now()
+
+	if _, err := b.Take("k", 1, 2, now); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := b.Refund("k", 2); err != nil {
+			t.Fatalf("Refund: %v", err)
+		}
+	}
+	for i, want := range []time.Duration{0, 0, time.Second} {
+		wait, err := b.Take("k", 1, 2, now)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if wait != want {
+			t.Errorf("take %d: wait = %s, want %s", i+1, wait, want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	parsed, err := parseRules("*:ip:300/1m, /login:ip:30/1m,/register:email:3/1h")
+	if err != nil {
+		t.Fatalf("parseRules: %v", err)
+	}
+	want := Rule{Route: "/register", By: ByEmail, Limit: 3, Period: time.Hour}
+	if got := parsed["/register"]; len(got) != 1 || got[0] != want {
+		t.Errorf("/register rules = %v, want [%v]", got, want)
+	}
+	if len(parsed[AnyRoute]) != 1 || len(parsed["/login"]) != 1 {
+		t.Errorf("parsed = %v", parsed)
+	}
+
+	for _, bad := range []string{"/login:ip", "/login:device:1/1m", "/login:ip:0/1m", "/login:ip:1/0s", "/login:ip:1"} {
+		if _, err = parseRules(bad); err == nil {
+			t.Errorf("parseRules(%q) accepted an invalid rule", bad)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"flag"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	redisAddr     = flag.String("rateLimitRedisAddr", "localhost:6379", "Redis address for the redis rate limit backend")
+	redisPassword = flag.String("rateLimitRedisPassword", "", "Redis password for the redis rate limit backend")
+	redisDB       = flag.Int("rateLimitRedisDB", 0, "Redis database for the redis rate limit backend")
+)
+
+// takeScript refills and takes from a bucket atomically. The bucket is a hash
+// of the token count and the last update in milliseconds, and it expires once
+// it would be full again. It returns the wait in milliseconds, 0 when allowed.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate)
+else
+	tokens = tokens - 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+return wait
+`)
+
+// refundScript puts one token back into a bucket that still exists. A bucket
+// that expired in the meantime is full anyway.
+var refundScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens == nil then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(burst, tokens + 1)))
+return 1
+`)
+
+// redisBackend shares buckets between instances. Any server speaking the
+// Redis protocol with Lua scripting works, including local stand-ins.
+type redisBackend struct {
+	client *redis.Client
+}
+
+func newRedisBackend() *redisBackend {
+	return &redisBackend{
+		client: redis.NewClient(&redis.Options{
+			Addr:     *redisAddr,
+			Password: *redisPassword,
+			DB:       *redisDB,
+		}),
+	}
+}
+
+func (b *redisBackend) Take(key string, rate float64, burst int, now time.Time) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	perMillisecond := rate / float64(time.Second/time.Millisecond)
+	wait, err := takeScript.Run(ctx, b.client, []string{key}, perMillisecond, burst, now.UnixMilli()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (b *redisBackend) Refund(key string, burst int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return refundScript.Run(ctx, b.client, []string{key}, burst).Err()
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestRedisBackend(t *testing.T) (*redisBackend, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return &redisBackend{client: client}, server
+}
+
+func TestRedisBucket(t *testing.T) {
+	b, _ := newTestRedisBackend(t)
+	testBucket(t, b)
+}
+
+func TestRedisBucketExpires(t *testing.T) {
+	b, server := newTestRedisBackend(t)
+	now := time.Unix(1700000000, 0)
+
+	if _, err := b.Take("k", 1, 2, now); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if !server.Exists("k") {
+		t.Fatal("bucket was not stored")
+	}
+	// Back to full after one second, so the key must not outlive that by much.
+	if ttl := server.TTL("k"); ttl <= 0 || ttl > 2*time.Second {
+		t.Errorf("bucket TTL = %s, want about 1s", ttl)
+	}
+
+	if err := b.Refund("missing", 2); err != nil {
+		t.Fatalf("Refund of an expired bucket: %v", err)
+	}
+	if server.Exists("missing") {
+		t.Error("refund created a bucket")
+	}
+}
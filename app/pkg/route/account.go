@@ -0,0 +1,139 @@
+package route
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+	"time"
+)
+
+func handleAccountRoutes(ctx *fasthttp.RequestCtx, path string) {
+	switch {
+	case path == "/me" && ctx.IsDelete():
+		handleDeleteAccount(ctx)
+	case path == "/me/export" && ctx.IsGet():
+		handleExportAccount(ctx)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+// handleDeleteAccount takes the password, and with 2FA on a code or recovery
+// code, in the same shape as disabling 2FA.
+func handleDeleteAccount(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	var req models.MFAReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Password == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Password is required")
+		return
+	}
+
+	if err = service.DeleteAccount(userID, clientIP(ctx), req); err != nil {
+		writePasswordCheckError(ctx, err, "Failed to delete account")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Account deleted, a link to restore it was sent to your email", nil)
+}
+
+// handleExportAccount returns a ZIP archive with one JSON file per section,
+// or a single JSON document with ?format=json.
+func handleExportAccount(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	format := string(ctx.QueryArgs().Peek("format"))
+	if format != "" && format != "json" && format != "zip" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Format must be json or zip")
+		return
+	}
+
+	export, err := service.ExportAccount(userID)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to export account")
+		return
+	}
+	if format == "json" {
+		respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Account export", export)
+		return
+	}
+
+	archive, err := exportArchive(export)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to export account")
+		return
+	}
+	ctx.SetContentType("application/zip")
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d-%s.zip"`, userID, time.Now().UTC().Format("20060102")))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(archive)
+}
+
+func exportArchive(export *models.AccountExport) ([]byte, error) {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"ai_settings.json", export.Settings},
+		{"passkeys.json", export.Passkeys},
+		{"login_history.json", export.LoginHistory},
+		{"audit_events.json", export.AuditEvents},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleRestoreAccount is the link sent when an account is deleted. The token
+// comes as a query parameter or, from a frontend, as a JSON body.
+func handleRestoreAccount(ctx *fasthttp.RequestCtx) {
+	token := string(ctx.QueryArgs().Peek("token"))
+	if token == "" && ctx.IsPost() {
+		var req models.TokenReq
+		if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Token is required")
+		return
+	}
+
+	if err := service.RestoreAccount(token); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to restore account")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Account restored, sign in to continue", nil)
+}
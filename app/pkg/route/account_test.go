@@ -0,0 +1,74 @@
+package route
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"io"
+	"testing"
+)
+
+func TestExportArchive(t *testing.T) {
+	export := &models.AccountExport{
+		Profile:      models.Profile{ID: 5, Email: "export@example.com", IsVerified: true},
+		Settings:     []*models.UserSettings{{ID: 9, AIToken: "sk-…1234", TokenFingerprint: "ab12"}},
+		Passkeys:     []*models.Passkey{{ID: 3, Name: "laptop"}},
+		LoginHistory: []*models.LoginEvent{{Method: "password", IP: "192.0.2.1", Success: true}},
+		AuditEvents:  []*models.AuditEvent{{Event: "data_exported"}},
+	}
+
+	archive, err := exportArchive(export)
+	if err != nil {
+		t.Fatalf("exportArchive: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", file.Name, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", file.Name, err)
+		}
+		files[file.Name] = data
+	}
+
+	for name, into := range map[string]interface{}{
+		"profile.json":       &models.Profile{},
+		"ai_settings.json":   &[]*models.UserSettings{},
+		"passkeys.json":      &[]*models.Passkey{},
+		"login_history.json": &[]*models.LoginEvent{},
+		"audit_events.json":  &[]*models.AuditEvent{},
+	} {
+		data, ok := files[name]
+		if !ok {
+			t.Errorf("%s missing from the archive", name)
+			continue
+		}
+		if err := json.Unmarshal(data, into); err != nil {
+			t.Errorf("%s is not valid JSON: %v", name, err)
+		}
+	}
+	if len(files) != 5 {
+		t.Errorf("archive has %d files, want 5", len(files))
+	}
+
+	var profile models.Profile
+	_ = json.Unmarshal(files["profile.json"], &profile)
+	if profile != export.Profile {
+		t.Errorf("profile.json = %+v, want %+v", profile, export.Profile)
+	}
+	var settings []*models.UserSettings
+	_ = json.Unmarshal(files["ai_settings.json"], &settings)
+	if len(settings) != 1 || settings[0].AIToken != "sk-…1234" {
+		t.Errorf("ai_settings.json = %s", files["ai_settings.json"])
+	}
+}
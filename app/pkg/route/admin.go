@@ -0,0 +1,50 @@
+package route
+
+import (
+	"encoding/json"
+	"github.com/Dimoonevs/user-service/app/internal/clients"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+)
+
+// handleAdminRoutes serves endpoints for operators. Callers authenticate as
+// a service client with the admin scope.
+func handleAdminRoutes(ctx *fasthttp.RequestCtx, path string) {
+	client, err := authenticateClient(ctx)
+	if err != nil {
+		ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="user-service"`)
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Unauthorized")
+		return
+	}
+	if !client.HasScope(clients.ScopeAdmin) {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusForbidden, nil, "Insufficient scope")
+		return
+	}
+
+	switch {
+	case path == "/admin/unlock" && ctx.IsPost():
+		handleAdminUnlock(ctx)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+func handleAdminUnlock(ctx *fasthttp.RequestCtx) {
+	var req models.UnlockReq
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Email == "" && req.IP == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Email or IP is required")
+		return
+	}
+
+	if err := service.UnlockLogin(req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to unlock")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Unlock successful", req)
+}
@@ -0,0 +1,104 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+)
+
+func handleEmailRoutes(ctx *fasthttp.RequestCtx, path string) {
+	switch {
+	case path == "/email/change" && ctx.IsPost():
+		handleRequestEmailChange(ctx)
+	case path == "/email/confirm" && ctx.IsPost():
+		handleConfirmEmailChange(ctx)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+func handleRequestEmailChange(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	var req models.ChangeEmailReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.NewEmail == "" || req.Password == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "New email and Password is required")
+		return
+	}
+
+	if err = service.RequestEmailChange(userID, clientIP(ctx), req); err != nil {
+		if errors.Is(err, service.ErrEmailTaken) {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusConflict, err, "Failed to change email")
+			return
+		}
+		writePasswordCheckError(ctx, err, "Failed to change email")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Code sent to the new email", req.NewEmail)
+}
+
+func handleConfirmEmailChange(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	var req models.ChangeEmailReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Code == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Code is required")
+		return
+	}
+
+	tokens, err := service.ConfirmEmailChange(userID, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrEmailTaken) {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusConflict, err, "Failed to change email")
+			return
+		}
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to change email")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Change email successful", tokens)
+}
+
+// handleRevertEmailChange is the link sent to the previous address. The
+// token comes as a query parameter or, from a frontend, as a JSON body.
+func handleRevertEmailChange(ctx *fasthttp.RequestCtx) {
+	token := string(ctx.QueryArgs().Peek("token"))
+	if token == "" && ctx.IsPost() {
+		var req models.TokenReq
+		if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Token is required")
+		return
+	}
+
+	if err := service.RevertEmailChange(token); err != nil {
+		if errors.Is(err, service.ErrEmailTaken) {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusConflict, err, "Failed to restore email")
+			return
+		}
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to restore email")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Email restored, signed out everywhere and a code to set a new password was sent", nil)
+}
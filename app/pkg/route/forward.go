@@ -0,0 +1,101 @@
+package route
+
+import (
+	"flag"
+	"github.com/Dimoonevs/user-service/app/internal/lib"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	forwardAuthCacheTTL = flag.Duration("forwardAuthCacheTTL", 10*time.Second, "How long successful forward-auth checks are cached, 0 disables the cache")
+
+	forwardCache = &forwardAuthCache{entries: make(map[string]forwardAuthEntry)}
+)
+
+type forwardAuthEntry struct {
+	userID    int
+	email     string
+	scope     string
+	expiresAt time.Time
+}
+
+// forwardAuthCache remembers recently verified tokens so that a reverse proxy
+// checking every request of a page does not hit the revocation store each time.
+type forwardAuthCache struct {
+	mu        sync.Mutex
+	entries   map[string]forwardAuthEntry
+	lastSweep time.Time
+}
+
+func (c *forwardAuthCache) get(key string) (forwardAuthEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return forwardAuthEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *forwardAuthCache) put(key string, entry forwardAuthEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > *forwardAuthCacheTTL {
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = entry
+}
+
+// handleForwardAuth implements the nginx auth_request / Traefik ForwardAuth
+// contract: 200 with identity headers for a valid token, 401 otherwise.
+func handleForwardAuth(ctx *fasthttp.RequestCtx) {
+	token, err := jwt.TokenFromRequest(ctx)
+	if err == nil && *forwardAuthCacheTTL > 0 {
+		if entry, ok := forwardCache.get(lib.HashToken(token)); ok {
+			writeForwardAuthHeaders(ctx, entry)
+			return
+		}
+	}
+
+	jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+		userID, err := getUserIDFromContext(ctx)
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			return
+		}
+		entry := forwardAuthEntry{userID: userID}
+		entry.email, _ = ctx.UserValue("email").(string)
+		entry.scope, _ = ctx.UserValue("scope").(string)
+
+		if *forwardAuthCacheTTL > 0 {
+			entry.expiresAt = time.Now().Add(*forwardAuthCacheTTL)
+			if exp, ok := ctx.UserValue("exp").(float64); ok && time.Unix(int64(exp), 0).Before(entry.expiresAt) {
+				entry.expiresAt = time.Unix(int64(exp), 0)
+			}
+			forwardCache.put(lib.HashToken(token), entry)
+		}
+		writeForwardAuthHeaders(ctx, entry)
+	})(ctx)
+}
+
+func writeForwardAuthHeaders(ctx *fasthttp.RequestCtx, entry forwardAuthEntry) {
+	ctx.Response.Header.Set("X-User-Id", strconv.Itoa(entry.userID))
+	ctx.Response.Header.Set("X-User-Email", entry.email)
+	if entry.scope != "" {
+		ctx.Response.Header.Set("X-User-Scope", entry.scope)
+	}
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
@@ -0,0 +1,145 @@
+package route
+
+import (
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/clients"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+)
+
+const (
+	authMethodMTLS  = "mtls"
+	authMethodToken = "token"
+)
+
+// InternalRequestHandler serves the API for other backend services, such as
+// the video-service fetching the AI settings it runs a user's jobs with. It
+// is served on its own listener and never on the public port.
+func InternalRequestHandler(ctx *fasthttp.RequestCtx) {
+	path := string(ctx.URI().Path())
+
+	switch {
+	case path == "/oauth/token" && ctx.IsPost():
+		handleClientToken(ctx)
+	case strings.HasPrefix(path, "/internal/"):
+		handleInternalRoutes(ctx, path)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+// handleClientToken implements the client credentials grant of RFC 6749
+// section 4.4.
+func handleClientToken(ctx *fasthttp.RequestCtx) {
+	client, err := authenticateClient(ctx)
+	if err != nil {
+		ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="user-service"`)
+		writeOAuthJSON(ctx, fasthttp.StatusUnauthorized, oauthError{Error: "invalid_client"})
+		return
+	}
+	if grantType := string(ctx.PostArgs().Peek("grant_type")); grantType != "client_credentials" {
+		writeOAuthJSON(ctx, fasthttp.StatusBadRequest, oauthError{Error: "unsupported_grant_type"})
+		return
+	}
+
+	token, err := service.IssueClientToken(client, string(ctx.PostArgs().Peek("scope")))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidScope) {
+			writeOAuthJSON(ctx, fasthttp.StatusBadRequest, oauthError{Error: "invalid_scope"})
+			return
+		}
+		writeOAuthJSON(ctx, fasthttp.StatusInternalServerError, oauthError{Error: "server_error"})
+		return
+	}
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	writeOAuthJSON(ctx, fasthttp.StatusOK, token)
+}
+
+func handleInternalRoutes(ctx *fasthttp.RequestCtx, path string) {
+	client, caller, err := authenticateService(ctx)
+	if err != nil {
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="user-service"`)
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Unauthorized")
+		return
+	}
+	if !client.HasScope(clients.ScopeAITokens) {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusForbidden, nil, "Insufficient scope")
+		return
+	}
+
+	// /internal/users/{userID}/settings/{settingsID}
+	parts := strings.Split(strings.TrimPrefix(path, "/internal/"), "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "settings" && ctx.IsGet():
+		handleGetServiceAISettings(ctx, caller, parts[1], parts[3])
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+// authenticateService accepts a client certificate verified against the
+// client CA, whose common name is the client ID, or a bearer token from the
+// client credentials grant.
+func authenticateService(ctx *fasthttp.RequestCtx) (*clients.Client, models.ServiceCaller, error) {
+	caller := models.ServiceCaller{IP: ctx.RemoteIP().String()}
+
+	if state := ctx.TLSConnectionState(); state != nil && len(state.VerifiedChains) > 0 {
+		id := state.VerifiedChains[0][0].Subject.CommonName
+		client, ok := clients.Lookup(id)
+		if !ok {
+			return nil, caller, clients.ErrInvalidClient
+		}
+		caller.ClientID = client.ID
+		caller.AuthMethod = authMethodMTLS
+		return client, caller, nil
+	}
+
+	auth := string(ctx.Request.Header.Peek("Authorization"))
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return nil, caller, errors.New("missing client certificate or token")
+	}
+	claims, err := jwt.ParseClientToken(auth[len(prefix):])
+	if err != nil {
+		return nil, caller, err
+	}
+	id, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	// The client has to be registered still, so removing it from oauthClients
+	// cuts off tokens it already holds.
+	if _, ok := clients.Lookup(id); !ok {
+		return nil, caller, clients.ErrInvalidClient
+	}
+	caller.ClientID = id
+	caller.AuthMethod = authMethodToken
+	return &clients.Client{ID: id, Scopes: strings.Fields(scope)}, caller, nil
+}
+
+func handleGetServiceAISettings(ctx *fasthttp.RequestCtx, caller models.ServiceCaller, userIDParam, settingsIDParam string) {
+	userID, err := strconv.Atoi(userIDParam)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid user id")
+		return
+	}
+	settingsID, err := strconv.Atoi(settingsIDParam)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid settings id")
+		return
+	}
+
+	settings, err := service.GetServiceAISettings(caller, userID, settingsID)
+	if err != nil {
+		if errors.Is(err, service.ErrSettingsNotFound) {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, err, "Settings not found")
+			return
+		}
+		respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to get AI settings")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Get AI settings successful", settings)
+}
@@ -0,0 +1,27 @@
+package route
+
+import (
+	"flag"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strings"
+)
+
+var trustProxyHeaders = flag.Bool("trustProxyHeaders", false, "Take the client IP from X-Forwarded-For or X-Real-IP, enable only behind a reverse proxy that sets them")
+
+// clientIP returns the address failed logins and rate limits are counted
+// against.
+func clientIP(ctx *fasthttp.RequestCtx) string {
+	if *trustProxyHeaders {
+		if forwarded := string(ctx.Request.Header.Peek("X-Forwarded-For")); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(string(ctx.Request.Header.Peek("X-Real-IP")))); ip != nil {
+			return ip.String()
+		}
+	}
+	return ctx.RemoteIP().String()
+}
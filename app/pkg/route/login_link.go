@@ -0,0 +1,93 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+	"html/template"
+)
+
+func handleRequestLoginLink(ctx *fasthttp.RequestCtx) {
+	var req models.UsersReq
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Email == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Email is required")
+		return
+	}
+
+	if service.PrivacyMode() {
+		respondPrivately(ctx, "login link", func() error {
+			return service.RequestLoginLink(req.Email)
+		})
+		return
+	}
+	if err := service.RequestLoginLink(req.Email); err != nil {
+		if errors.Is(err, service.ErrLoginLinkRateLimited) {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusTooManyRequests, err, "Failed to send login link")
+			return
+		}
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to send login link")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Login link sent to email", req.Email)
+}
+
+// loginLinkPage is what opening the emailed link shows. Mail scanners and
+// link previews follow links with GET, so the link is only used up by the
+// POST the button sends.
+var loginLinkPage = template.Must(template.New("login_link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Sign in</title></head>
+<body>
+<form method="post">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// handleConsumeLoginLink answers GET with a confirmation page and signs in on
+// POST. The token is taken from the query string, so the emailed link can be
+// opened directly, or from a form or JSON body.
+func handleConsumeLoginLink(ctx *fasthttp.RequestCtx) {
+	token := string(ctx.QueryArgs().Peek("token"))
+	if token == "" && ctx.IsPost() {
+		token = string(ctx.PostArgs().Peek("token"))
+	}
+	if token == "" && ctx.IsPost() {
+		var req models.TokenReq
+		if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Token is required")
+		return
+	}
+
+	if ctx.IsGet() {
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+		ctx.Response.Header.Set("Referrer-Policy", "no-referrer")
+		if err := loginLinkPage.Execute(ctx, token); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to render page")
+		}
+		return
+	}
+
+	resp, err := service.ConsumeLoginLink(token, clientIP(ctx))
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Failed to login")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Login successful", resp)
+}
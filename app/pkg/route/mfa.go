@@ -0,0 +1,112 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+)
+
+func handleTwoFactorRoutes(ctx *fasthttp.RequestCtx, path string) {
+	switch {
+	case path == "/2fa/totp/setup" && ctx.IsPost():
+		handleTOTPSetup(ctx)
+	case path == "/2fa/totp/confirm" && ctx.IsPost():
+		handleTOTPConfirm(ctx)
+	case path == "/2fa/disable" && ctx.IsPost():
+		handleTwoFactorDisable(ctx)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+func handleTOTPSetup(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	email, _ := ctx.UserValue("email").(string)
+
+	setup, err := service.SetupTOTP(userID, email)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to set up two-factor authentication")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Scan the QR code and confirm with a code", setup)
+}
+
+func handleTOTPConfirm(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	var req models.MFAReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Code == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Code is required")
+		return
+	}
+
+	recoveryCodes, err := service.ConfirmTOTP(userID, req.Code)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to confirm two-factor authentication")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Two-factor authentication enabled", recoveryCodes)
+}
+
+func handleTwoFactorDisable(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+
+	var req models.MFAReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Password == "" || (req.Code == "" && req.RecoveryCode == "") {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Password and Code or Recovery code is required")
+		return
+	}
+
+	if err = service.DisableTOTP(userID, clientIP(ctx), req); err != nil {
+		writePasswordCheckError(ctx, err, "Failed to disable two-factor authentication")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Two-factor authentication disabled", nil)
+}
+
+func handleLoginMFA(ctx *fasthttp.RequestCtx) {
+	var req models.MFAReq
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "MFA token and Code or Recovery code is required")
+		return
+	}
+
+	tokens, err := service.LoginWithMFA(req, clientIP(ctx))
+	var blocked *loginguard.BlockedError
+	if errors.As(err, &blocked) {
+		writePasswordCheckError(ctx, err, "Failed to login")
+		return
+	}
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Failed to login")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Login successful", tokens)
+}
@@ -0,0 +1,88 @@
+package route
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/Dimoonevs/user-service/app/internal/clients"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/valyala/fasthttp"
+	"net/url"
+	"strings"
+)
+
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func handleIntrospect(ctx *fasthttp.RequestCtx) {
+	client, err := authenticateClient(ctx)
+	if err != nil {
+		ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="user-service"`)
+		writeOAuthJSON(ctx, fasthttp.StatusUnauthorized, oauthError{Error: "invalid_client"})
+		return
+	}
+	if !client.HasScope(clients.ScopeIntrospect) {
+		writeOAuthJSON(ctx, fasthttp.StatusForbidden, oauthError{Error: "insufficient_scope"})
+		return
+	}
+
+	token := string(ctx.PostArgs().Peek("token"))
+	if token == "" {
+		writeOAuthJSON(ctx, fasthttp.StatusBadRequest, oauthError{Error: "invalid_request", ErrorDescription: "token is required"})
+		return
+	}
+
+	resp := service.Introspect(token, string(ctx.PostArgs().Peek("token_type_hint")))
+	writeOAuthJSON(ctx, fasthttp.StatusOK, resp)
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post
+// credentials as described in RFC 6749 section 2.3.1.
+func authenticateClient(ctx *fasthttp.RequestCtx) (*clients.Client, error) {
+	id, secret, ok := basicAuth(ctx)
+	if !ok {
+		id = string(ctx.PostArgs().Peek("client_id"))
+		secret = string(ctx.PostArgs().Peek("client_secret"))
+	}
+	if id == "" || secret == "" {
+		return nil, clients.ErrInvalidClient
+	}
+	return clients.Authenticate(id, secret)
+}
+
+func basicAuth(ctx *fasthttp.RequestCtx) (string, string, bool) {
+	auth := string(ctx.Request.Header.Peek("Authorization"))
+	const prefix = "Basic "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	id, secret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+	// Client credentials are form-urlencoded before being put in the header.
+	if id, err = url.QueryUnescape(id); err != nil {
+		return "", "", false
+	}
+	if secret, err = url.QueryUnescape(secret); err != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func writeOAuthJSON(ctx *fasthttp.RequestCtx, statusCode int, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "no-store")
+	ctx.SetStatusCode(statusCode)
+	ctx.SetBody(body)
+}
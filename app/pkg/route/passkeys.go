@@ -0,0 +1,164 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+)
+
+func handlePasskeyRoutes(ctx *fasthttp.RequestCtx, path string) {
+	switch {
+	case path == "/passkeys" && ctx.IsGet():
+		handleListPasskeys(ctx)
+	case path == "/passkeys/register/begin" && ctx.IsPost():
+		handleBeginPasskeyRegistration(ctx)
+	case path == "/passkeys/register/finish" && ctx.IsPost():
+		handleFinishPasskeyRegistration(ctx)
+	case strings.HasPrefix(path, "/passkeys/") && ctx.IsPatch():
+		handleRenamePasskey(ctx, strings.TrimPrefix(path, "/passkeys/"))
+	case strings.HasPrefix(path, "/passkeys/") && ctx.IsDelete():
+		handleDeletePasskey(ctx, strings.TrimPrefix(path, "/passkeys/"))
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+func handleListPasskeys(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	passkeys, err := service.ListPasskeys(userID)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to get passkeys")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Get passkeys successful", passkeys)
+}
+
+func handleBeginPasskeyRegistration(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	ceremony, err := service.BeginPasskeyRegistration(userID)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to start passkey registration")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Passkey registration started", ceremony)
+}
+
+// handleFinishPasskeyRegistration takes the PublicKeyCredential from the
+// browser as the body, and the session ID and passkey name as query args.
+func handleFinishPasskeyRegistration(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	sessionID := string(ctx.QueryArgs().Peek("session_id"))
+	if sessionID == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Session id is required")
+		return
+	}
+	name := string(ctx.QueryArgs().Peek("name"))
+
+	if err = service.FinishPasskeyRegistration(userID, sessionID, name, ctx.PostBody()); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to register passkey")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusCreated, "Passkey registered", nil)
+}
+
+func handleRenamePasskey(ctx *fasthttp.RequestCtx, id string) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	passkeyID, err := strconv.Atoi(id)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid passkey id")
+		return
+	}
+	var req models.PasskeyReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.Name == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Name is required")
+		return
+	}
+
+	if err = service.RenamePasskey(userID, passkeyID, req.Name); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to rename passkey")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Rename passkey successful", nil)
+}
+
+func handleDeletePasskey(ctx *fasthttp.RequestCtx, id string) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	passkeyID, err := strconv.Atoi(id)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid passkey id")
+		return
+	}
+
+	if err = service.DeletePasskey(userID, passkeyID); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to delete passkey")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Delete passkey successful", nil)
+}
+
+func handleBeginPasskeyLogin(ctx *fasthttp.RequestCtx) {
+	var req models.PasskeyReq
+	if body := ctx.PostBody(); len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+			return
+		}
+	}
+
+	ceremony, err := service.BeginPasskeyLogin(req.Email, clientIP(ctx))
+	if err != nil {
+		writePasswordCheckError(ctx, err, "Failed to start passkey login")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Passkey login started", ceremony)
+}
+
+func handleFinishPasskeyLogin(ctx *fasthttp.RequestCtx) {
+	sessionID := string(ctx.QueryArgs().Peek("session_id"))
+	if sessionID == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Session id is required")
+		return
+	}
+
+	tokens, err := service.FinishPasskeyLogin(sessionID, ctx.PostBody(), clientIP(ctx))
+	var blocked *loginguard.BlockedError
+	if errors.As(err, &blocked) {
+		writePasswordCheckError(ctx, err, "Failed to login")
+		return
+	}
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Failed to login")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Login successful", tokens)
+}
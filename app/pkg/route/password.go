@@ -0,0 +1,95 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/passwordpolicy"
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+)
+
+func handlePasswordRoutes(ctx *fasthttp.RequestCtx) {
+	switch {
+	case ctx.IsPost():
+		handleChangePassword(ctx)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
+// rejectWeakPassword writes the failed policy rules as the response data and
+// reports whether the password was rejected.
+func rejectWeakPassword(ctx *fasthttp.RequestCtx, password, email string) bool {
+	err := passwordpolicy.Check(password, email)
+	if err == nil {
+		return false
+	}
+	if !writePolicyError(ctx, err) {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Password does not meet the policy")
+	}
+	return true
+}
+
+// writePolicyError writes the failed policy rules as the response data when
+// err is a password policy error, and reports whether it was.
+func writePolicyError(ctx *fasthttp.RequestCtx, err error) bool {
+	var policyErr *passwordpolicy.Error
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusBadRequest, "Password does not meet the policy", policyErr)
+	return true
+}
+
+func handleChangePassword(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+
+	var req models.ChangePasswordReq
+	if err = json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Current password and New password is required")
+		return
+	}
+	tokens, err := service.ChangePassword(userID, clientIP(ctx), req)
+	if writePolicyError(ctx, err) {
+		return
+	}
+	if err != nil {
+		writePasswordCheckError(ctx, err, "Failed to change password")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Change password successful", tokens)
+}
+
+// handleSecureAccount is the "this wasn't me" link from security emails. The
+// token comes as a query parameter or, from a frontend, as a JSON body.
+func handleSecureAccount(ctx *fasthttp.RequestCtx) {
+	token := string(ctx.QueryArgs().Peek("token"))
+	if token == "" && ctx.IsPost() {
+		var req models.TokenReq
+		if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Token is required")
+		return
+	}
+
+	if err := service.SecureAccount(token); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to secure account")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Signed out everywhere, a code to set a new password was sent to your email", nil)
+}
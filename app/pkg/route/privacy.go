@@ -0,0 +1,16 @@
+package route
+
+import (
+	"github.com/Dimoonevs/user-service/app/internal/service"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/valyala/fasthttp"
+)
+
+const privacyResponse = "If the email can be used, a message has been sent to it"
+
+// respondPrivately answers at once with the same response whatever the flow
+// would have returned, and runs the flow in the background.
+func respondPrivately(ctx *fasthttp.RequestCtx, name string, flow func() error) {
+	service.RunInBackground(name, flow)
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusAccepted, privacyResponse, nil)
+}
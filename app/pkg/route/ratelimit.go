@@ -0,0 +1,113 @@
+package route
+
+import (
+	"encoding/json"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/Dimoonevs/user-service/app/pkg/ratelimit"
+	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unlimitedRoutes are called by infrastructure once for every request it
+// serves: the reverse proxy asks /auth/forward and resource servers ask
+// /oauth/introspect. Their traffic comes from a handful of addresses and
+// would exhaust any per-IP limit meant for users.
+var unlimitedRoutes = map[string]bool{
+	"/auth/forward":     true,
+	"/oauth/introspect": true,
+}
+
+// allowRequest applies the rate limit rules of the path and writes a 429
+// response when one of them is exhausted. It runs before authentication, so
+// rules by user take the user from the access token of the request and are
+// skipped for requests without a valid one. A rejected request gives back
+// the tokens it took from the rules checked before, so it only counts
+// against the limit that rejected it.
+// The limiter fails open: a backend error lets the request in.
+func allowRequest(ctx *fasthttp.RequestCtx, path string) bool {
+	if unlimitedRoutes[path] {
+		return true
+	}
+
+	var email *string
+	var taken []takenToken
+	for _, rule := range ratelimit.RulesFor(path) {
+		var value string
+		switch rule.By {
+		case ratelimit.ByIP:
+			value = clientIP(ctx)
+		case ratelimit.ByEmail:
+			if email == nil {
+				email = new(string)
+				*email = requestEmail(ctx)
+			}
+			value = *email
+		case ratelimit.ByUser:
+			value = requestUser(ctx)
+		}
+		if value == "" {
+			continue
+		}
+
+		retryAfter, err := ratelimit.Allow(rule, value)
+		if err != nil {
+			logrus.Errorf("Cannot check rate limit: %v", err)
+			continue
+		}
+		if retryAfter > 0 {
+			refundTokens(taken)
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respJSON.WriteJSONError(ctx, fasthttp.StatusTooManyRequests, nil, "Too many requests")
+			return false
+		}
+		taken = append(taken, takenToken{rule: rule, value: value})
+	}
+	return true
+}
+
+type takenToken struct {
+	rule  ratelimit.Rule
+	value string
+}
+
+func refundTokens(taken []takenToken) {
+	for _, t := range taken {
+		if err := ratelimit.Refund(t.rule, t.value); err != nil {
+			logrus.Errorf("Cannot refund rate limit token: %v", err)
+		}
+	}
+}
+
+// requestUser returns the ID of the user the access token of the request
+// was issued to, or "" without a valid token.
+func requestUser(ctx *fasthttp.RequestCtx) string {
+	tokenStr, err := jwt.TokenFromRequest(ctx)
+	if err != nil {
+		return ""
+	}
+	claims, err := jwt.ParseToken(tokenStr)
+	if err != nil {
+		return ""
+	}
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return ""
+	}
+	return strconv.Itoa(int(userID))
+}
+
+// requestEmail returns the normalized email field of a JSON body, so limits
+// by target address hold however the address is capitalized.
+func requestEmail(ctx *fasthttp.RequestCtx) string {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
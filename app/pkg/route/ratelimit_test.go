@@ -0,0 +1,146 @@
+package route
+
+import (
+	"flag"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/Dimoonevs/user-service/app/pkg/ratelimit"
+	"github.com/valyala/fasthttp"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func setRateLimitRules(t *testing.T, rules string) {
+	t.Helper()
+
+	if err := flag.Set("rateLimitRules", rules); err != nil {
+		t.Fatalf("set rules: %v", err)
+	}
+	if err := ratelimit.LoadRules(); err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+}
+
+func limitedRequest(ip, email string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetBodyString(`{"email":"` + email + `"}`)
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(ip)}, nil)
+	return ctx
+}
+
+func TestAllowRequestSetsRetryAfter(t *testing.T) {
+	setRateLimitRules(t, "/retry:ip:2/1m")
+
+	for i := 0; i < 2; i++ {
+		if !allowRequest(limitedRequest("192.0.2.10", ""), "/retry") {
+			t.Fatalf("request %d was limited", i+1)
+		}
+	}
+
+	ctx := limitedRequest("192.0.2.10", "")
+	if allowRequest(ctx, "/retry") {
+		t.Fatal("request over the limit was allowed")
+	}
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", code)
+	}
+	// One token per 30 seconds.
+	retryAfter, err := strconv.Atoi(string(ctx.Response.Header.Peek("Retry-After")))
+	if err != nil || retryAfter < 29 || retryAfter > 30 {
+		t.Errorf("Retry-After = %q, want 30", ctx.Response.Header.Peek("Retry-After"))
+	}
+
+	if !allowRequest(limitedRequest("192.0.2.11", ""), "/retry") {
+		t.Error("another IP was limited")
+	}
+}
+
+func TestAllowRequestRefundsEarlierRules(t *testing.T) {
+	setRateLimitRules(t, "/refund:ip:1/1h,/refund:email:1/1h")
+
+	if !allowRequest(limitedRequest("192.0.2.20", "a@example.com"), "/refund") {
+		t.Fatal("first request was limited")
+	}
+	// Rejected by the email rule after the IP rule took its only token.
+	if allowRequest(limitedRequest("192.0.2.21", "A@example.com"), "/refund") {
+		t.Fatal("second request for the same email was allowed")
+	}
+	// The rejected request must not have used up the IP's token.
+	if !allowRequest(limitedRequest("192.0.2.21", "b@example.com"), "/refund") {
+		t.Error("IP stayed limited by a request that was rejected")
+	}
+}
+
+func TestAllowRequestSkipsInfrastructureEndpoints(t *testing.T) {
+	setRateLimitRules(t, "*:ip:1/1h")
+
+	for path := range unlimitedRoutes {
+		for i := 0; i < 3; i++ {
+			if !allowRequest(limitedRequest("192.0.2.30", ""), path) {
+				t.Fatalf("request %d to %s was limited", i+1, path)
+			}
+		}
+	}
+}
+
+func signedRequest(t *testing.T, ip string, userID int) *fasthttp.RequestCtx {
+	t.Helper()
+
+	token, err := jwt.GenerateJWT("user@example.com", userID)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	ctx := limitedRequest(ip, "")
+	ctx.Request.Header.Set("Authorization", "Bearer "+token)
+	return ctx
+}
+
+func TestAllowRequestLimitsByUser(t *testing.T) {
+	setRateLimitRules(t, "/user:user:1/1h")
+
+	if !allowRequest(signedRequest(t, "192.0.2.40", 7), "/user") {
+		t.Fatal("first request was limited")
+	}
+
+	// The same user from another IP is still limited.
+	if allowRequest(signedRequest(t, "192.0.2.41", 7), "/user") {
+		t.Error("second request of the same user was allowed")
+	}
+
+	if !allowRequest(signedRequest(t, "192.0.2.41", 8), "/user") {
+		t.Error("another user was limited")
+	}
+
+	// Rules by user do not apply without a valid token.
+	unsigned := limitedRequest("192.0.2.41", "")
+	unsigned.Request.Header.Set("Authorization", "Bearer not-a-token")
+	if !allowRequest(unsigned, "/user") {
+		t.Error("a request without a valid token was limited by user")
+	}
+}
+
+// JWT routes are limited before the token is checked, so a flood of requests
+// with bad tokens is cut off as well.
+func TestRequestHandlerLimitsJWTRoutes(t *testing.T) {
+	setRateLimitRules(t, "*:ip:2/1h")
+
+	for i, want := range []int{fasthttp.StatusUnauthorized, fasthttp.StatusUnauthorized, fasthttp.StatusTooManyRequests} {
+		ctx := limitedRequest("192.0.2.50", "")
+		ctx.Request.SetRequestURI("/users/settings")
+		RequestHandler(ctx)
+		if got := ctx.Response.StatusCode(); got != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, got, want)
+		}
+	}
+
+	// A signed-in user is limited by the same rule.
+	ctx := signedRequest(t, "192.0.2.50", 7)
+	ctx.Request.SetRequestURI("/users/password")
+	RequestHandler(ctx)
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusTooManyRequests {
+		t.Errorf("signed-in request: status = %d, want %d", got, fasthttp.StatusTooManyRequests)
+	}
+}
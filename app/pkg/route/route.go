@@ -5,13 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Dimoonevs/go-prometheus-metrics/metrics"
+	"github.com/Dimoonevs/user-service/app/internal/ai"
+	"github.com/Dimoonevs/user-service/app/internal/loginguard"
 	"github.com/Dimoonevs/user-service/app/internal/models"
+	"github.com/Dimoonevs/user-service/app/internal/revocation"
 	"github.com/Dimoonevs/user-service/app/internal/service"
 	"github.com/Dimoonevs/user-service/app/pkg/jwt"
 	"github.com/Dimoonevs/video-service/app/pkg/respJSON"
 	"github.com/valyala/fasthttp"
 	"log"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type checkResponse struct {
@@ -19,6 +25,18 @@ type checkResponse struct {
 	Email string  `json:"email"`
 }
 
+// CheckConfig reports settings the handlers cannot run with. It must be
+// called after the flags are parsed.
+func CheckConfig() error {
+	return service.CheckConfig()
+}
+
+// IsTokenRevoked checks access tokens against the revocation store, see
+// jwt.SetRevocationChecker.
+func IsTokenRevoked(jti string, userID int, issuedAt time.Time) (bool, error) {
+	return revocation.GetStore().IsRevoked(jti, userID, issuedAt)
+}
+
 func RequestHandler(ctx *fasthttp.RequestCtx) {
 	if string(ctx.Method()) == fasthttp.MethodOptions {
 		ctx.SetStatusCode(fasthttp.StatusOK)
@@ -34,6 +52,20 @@ func RequestHandler(ctx *fasthttp.RequestCtx) {
 
 	remainingPath := path[len("/users"):]
 
+	if !allowRequest(ctx, remainingPath) {
+		return
+	}
+
+	if remainingPath == "/auth/forward" {
+		handleForwardAuth(ctx)
+		return
+	}
+
+	if strings.HasPrefix(remainingPath, "/admin/") {
+		handleAdminRoutes(ctx, remainingPath)
+		return
+	}
+
 	if strings.HasPrefix(remainingPath, "/check") {
 		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
 			handleCheckRoutes(ctx)
@@ -41,9 +73,51 @@ func RequestHandler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	if strings.HasPrefix(remainingPath, "/logout") {
+		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+			handleLogoutRoutes(ctx, remainingPath)
+		})(ctx)
+		return
+	}
+
+	if strings.HasPrefix(remainingPath, "/2fa") {
+		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+			handleTwoFactorRoutes(ctx, remainingPath)
+		})(ctx)
+		return
+	}
+
+	if strings.HasPrefix(remainingPath, "/passkeys") {
+		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+			handlePasskeyRoutes(ctx, remainingPath)
+		})(ctx)
+		return
+	}
+
+	if remainingPath == "/email/change" || remainingPath == "/email/confirm" {
+		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+			handleEmailRoutes(ctx, remainingPath)
+		})(ctx)
+		return
+	}
+
+	if remainingPath == "/me" || strings.HasPrefix(remainingPath, "/me/") {
+		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+			handleAccountRoutes(ctx, remainingPath)
+		})(ctx)
+		return
+	}
+
+	if remainingPath == "/password" {
+		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
+			handlePasswordRoutes(ctx)
+		})(ctx)
+		return
+	}
+
 	if strings.HasPrefix(remainingPath, "/settings") {
 		jwt.JWTMiddleware(func(ctx *fasthttp.RequestCtx) {
-			handleSettingsRoutes(ctx)
+			handleSettingsRoutes(ctx, remainingPath)
 		})(ctx)
 		return
 	}
@@ -55,6 +129,28 @@ func RequestHandler(ctx *fasthttp.RequestCtx) {
 		handleUserVerify(ctx)
 	case remainingPath == "/login" && ctx.IsPost():
 		handleUserLogin(ctx)
+	case remainingPath == "/login/2fa" && ctx.IsPost():
+		handleLoginMFA(ctx)
+	case remainingPath == "/login/link" && ctx.IsPost():
+		handleRequestLoginLink(ctx)
+	case remainingPath == "/login/link/consume" && (ctx.IsGet() || ctx.IsPost()):
+		handleConsumeLoginLink(ctx)
+	case remainingPath == "/login/passkey/begin" && ctx.IsPost():
+		handleBeginPasskeyLogin(ctx)
+	case remainingPath == "/login/passkey/finish" && ctx.IsPost():
+		handleFinishPasskeyLogin(ctx)
+	case remainingPath == "/token/refresh" && ctx.IsPost():
+		handleRefreshToken(ctx)
+	case remainingPath == "/.well-known/jwks.json" && ctx.IsGet():
+		handleJWKS(ctx)
+	case remainingPath == "/oauth/introspect" && ctx.IsPost():
+		handleIntrospect(ctx)
+	case remainingPath == "/email/revert" && (ctx.IsGet() || ctx.IsPost()):
+		handleRevertEmailChange(ctx)
+	case remainingPath == "/account/secure" && (ctx.IsGet() || ctx.IsPost()):
+		handleSecureAccount(ctx)
+	case remainingPath == "/account/restore" && (ctx.IsGet() || ctx.IsPost()):
+		handleRestoreAccount(ctx)
 	case remainingPath == "/code" && ctx.IsPost():
 		handleSendVerificationEmailAgain(ctx)
 	case remainingPath == "/request/reset/password" && ctx.IsPost():
@@ -66,7 +162,16 @@ func RequestHandler(ctx *fasthttp.RequestCtx) {
 	}
 
 }
-func handleSettingsRoutes(ctx *fasthttp.RequestCtx) {
+func handleSettingsRoutes(ctx *fasthttp.RequestCtx, path string) {
+	if path == "/settings/models" {
+		if !ctx.IsGet() {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusMethodNotAllowed, nil, "Method not allowed")
+			return
+		}
+		handleGetModelCatalog(ctx)
+		return
+	}
+
 	switch {
 	case ctx.IsPost():
 		handleSetUserSettings(ctx)
@@ -79,6 +184,17 @@ func handleSettingsRoutes(ctx *fasthttp.RequestCtx) {
 	}
 }
 
+func handleLogoutRoutes(ctx *fasthttp.RequestCtx, path string) {
+	switch {
+	case path == "/logout" && ctx.IsPost():
+		handleLogout(ctx)
+	case path == "/logout/all" && ctx.IsPost():
+		handleLogoutAll(ctx)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, nil, "Endpoint not found")
+	}
+}
+
 func handleCheckRoutes(ctx *fasthttp.RequestCtx) {
 	switch {
 	case ctx.IsGet():
@@ -101,6 +217,20 @@ func handleUserRegister(ctx *fasthttp.RequestCtx) {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Email or Password is required")
 		return
 	}
+	if rejectWeakPassword(ctx, req.Password, req.Email) {
+		return
+	}
+
+	if service.PrivacyMode() {
+		respondPrivately(ctx, "registration", func() error {
+			if _, err := service.RegisterUser(req); err != nil {
+				return err
+			}
+			metrics.UserRegistered.Inc()
+			return nil
+		})
+		return
+	}
 
 	userID, err := service.RegisterUser(req)
 	if err != nil {
@@ -146,12 +276,56 @@ func handleUserLogin(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	token, err := service.LoginUser(req)
+	tokens, err := service.LoginUser(req, clientIP(ctx))
 	if err != nil {
-		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to login")
+		writePasswordCheckError(ctx, err, "Failed to login")
 		return
 	}
-	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Login successful", token)
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Login successful", tokens)
+}
+
+// writePasswordCheckError answers 429 with Retry-After while the login guard
+// blocks the account or IP, and 400 otherwise.
+func writePasswordCheckError(ctx *fasthttp.RequestCtx, err error, message string) {
+	var blocked *loginguard.BlockedError
+	if errors.As(err, &blocked) {
+		ctx.Response.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		respJSON.WriteJSONError(ctx, fasthttp.StatusTooManyRequests, err, message)
+		return
+	}
+	respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, message)
+}
+
+func handleRefreshToken(ctx *fasthttp.RequestCtx) {
+	body := ctx.PostBody()
+	var req models.RefreshTokenReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+		return
+	}
+	if req.RefreshToken == "" {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Refresh token is required")
+		return
+	}
+
+	tokens, err := service.RefreshTokens(req.RefreshToken)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Failed to refresh token")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Refresh token successful", tokens)
+}
+
+func handleJWKS(ctx *fasthttp.RequestCtx) {
+	body, err := json.Marshal(jwt.PublicJWKS())
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to encode JWKS")
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set("Cache-Control", "public, max-age=300")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(body)
 }
 
 func handleRequestResetPassword(ctx *fasthttp.RequestCtx) {
@@ -165,6 +339,12 @@ func handleRequestResetPassword(ctx *fasthttp.RequestCtx) {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Email is required")
 		return
 	}
+	if service.PrivacyMode() {
+		respondPrivately(ctx, "password reset", func() error {
+			return service.RequestResetPassword(req.Email)
+		})
+		return
+	}
 	if err := service.RequestResetPassword(req.Email); err != nil {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to reset password")
 		return
@@ -181,6 +361,10 @@ func handleConfirmResetPassword(ctx *fasthttp.RequestCtx) {
 	}
 	if req.Email == "" || req.Code == "" || req.Password == "" {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Email or Code or Password is required")
+		return
+	}
+	if rejectWeakPassword(ctx, req.Password, req.Email) {
+		return
 	}
 	if err := service.ConfirmResetPassword(req); err != nil {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to reset password")
@@ -200,6 +384,12 @@ func handleSendVerificationEmailAgain(ctx *fasthttp.RequestCtx) {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, nil, "Email is required")
 		return
 	}
+	if service.PrivacyMode() {
+		respondPrivately(ctx, "verification email", func() error {
+			return service.SendVerificationEmailAgain(req.Email)
+		})
+		return
+	}
 	if err := service.SendVerificationEmailAgain(req.Email); err != nil {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to send verification email")
 		return
@@ -225,7 +415,7 @@ func handleSetUserSettings(ctx *fasthttp.RequestCtx) {
 	}
 	err = service.UserSettings(userID, req)
 	if err != nil {
-		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to set user settings")
+		writeSettingsError(ctx, err, "Failed to set user settings")
 		return
 	}
 	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Set user settings successful", nil)
@@ -237,7 +427,7 @@ func handleGetUserSettings(ctx *fasthttp.RequestCtx) {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
 		return
 	}
-	resp, err := service.GetUserSettings(userID)
+	resp, err := service.GetMaskedUserSettings(userID)
 	if err != nil {
 		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to get user settings")
 		return
@@ -245,6 +435,32 @@ func handleGetUserSettings(ctx *fasthttp.RequestCtx) {
 	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Get user settings successful", resp)
 }
 
+// handleGetModelCatalog returns the models users can pick per capability.
+// With ?refresh=true only models available to the user's token are returned,
+// using the entry given by settings_id or the first one with a token.
+func handleGetModelCatalog(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	refresh := ctx.QueryArgs().GetBool("refresh")
+	settingsID := 0
+	if value := ctx.QueryArgs().Peek("settings_id"); len(value) > 0 {
+		if settingsID, err = strconv.Atoi(string(value)); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid settings id")
+			return
+		}
+	}
+
+	catalog, err := service.GetModelCatalog(userID, refresh, settingsID)
+	if err != nil {
+		writeSettingsError(ctx, err, "Failed to get model catalog")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Get model catalog successful", catalog)
+}
+
 func handleUpdateUserSettings(ctx *fasthttp.RequestCtx) {
 	userID, err := getUserIDFromContext(ctx)
 	if err != nil {
@@ -262,12 +478,64 @@ func handleUpdateUserSettings(ctx *fasthttp.RequestCtx) {
 		return
 	}
 	if err = service.UpdateUserSettings(userID, req); err != nil {
-		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to update user settings")
+		writeSettingsError(ctx, err, "Failed to update user settings")
 		return
 	}
 	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Update user settings successful", nil)
 }
 
+// writeSettingsError writes fields rejected by the AI provider as the
+// response data, so the frontend can point at them.
+func writeSettingsError(ctx *fasthttp.RequestCtx, err error, message string) {
+	var fieldsErr *ai.Error
+	switch {
+	case errors.As(err, &fieldsErr):
+		respJSON.WriteJSONResponse(ctx, fasthttp.StatusBadRequest, message, fieldsErr)
+	case errors.Is(err, ai.ErrProviderUnavailable):
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadGateway, err, message)
+	case errors.Is(err, service.ErrSettingsNotFound):
+		respJSON.WriteJSONError(ctx, fasthttp.StatusNotFound, err, message)
+	default:
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, message)
+	}
+}
+
+func handleLogout(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	var req models.RefreshTokenReq
+	if body := ctx.PostBody(); len(body) > 0 {
+		if err = json.Unmarshal(body, &req); err != nil {
+			respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Invalid JSON body")
+			return
+		}
+	}
+
+	jti, _ := ctx.UserValue("jti").(string)
+	exp, _ := ctx.UserValue("exp").(float64)
+	if err = service.Logout(userID, jti, time.Unix(int64(exp), 0), req.RefreshToken); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusBadRequest, err, "Failed to logout")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Logout successful", nil)
+}
+
+func handleLogoutAll(ctx *fasthttp.RequestCtx) {
+	userID, err := getUserIDFromContext(ctx)
+	if err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusUnauthorized, err, "Error getting user id: ")
+		return
+	}
+	if err = service.LogoutAll(userID); err != nil {
+		respJSON.WriteJSONError(ctx, fasthttp.StatusInternalServerError, err, "Failed to logout")
+		return
+	}
+	respJSON.WriteJSONResponse(ctx, fasthttp.StatusOK, "Logout from all sessions successful", nil)
+}
+
 func handleCheckConnect(ctx *fasthttp.RequestCtx) {
 	id, ok := ctx.UserValue("userID").(float64)
 	if !ok {
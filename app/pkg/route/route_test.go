@@ -0,0 +1,44 @@
+package route
+
+import (
+	"flag"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/valyala/fasthttp"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	for name, value := range map[string]string{
+		"secretKey":          "route-test-secret",
+		"revocationStore":    "memory",
+		"revocationCacheTTL": "0",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			panic(err)
+		}
+	}
+	if err := jwt.LoadKeys(); err != nil {
+		panic(err)
+	}
+	jwt.SetRevocationChecker(IsTokenRevoked)
+	os.Exit(m.Run())
+}
+
+// Writes to /settings/models must not fall through to the settings handlers.
+func TestModelCatalogRejectsOtherMethods(t *testing.T) {
+	for _, method := range []string{fasthttp.MethodPost, fasthttp.MethodPatch, fasthttp.MethodPut, fasthttp.MethodDelete} {
+		var req fasthttp.Request
+		req.Header.SetMethod(method)
+		req.SetBodyString(`{"gpt_model":"gpt-4o"}`)
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Init(&req, nil, nil)
+
+		handleSettingsRoutes(ctx, "/settings/models")
+
+		if got := ctx.Response.StatusCode(); got != fasthttp.StatusMethodNotAllowed {
+			t.Errorf("%s /settings/models: status = %d, want %d", method, got, fasthttp.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -4,18 +4,58 @@ import (
 	"flag"
 	"fmt"
 	"github.com/Dimoonevs/go-prometheus-metrics/metrics"
+	"github.com/Dimoonevs/user-service/app/pkg/envelope"
+	"github.com/Dimoonevs/user-service/app/pkg/extauthz"
+	"github.com/Dimoonevs/user-service/app/pkg/internalapi"
+	"github.com/Dimoonevs/user-service/app/pkg/jobs"
+	"github.com/Dimoonevs/user-service/app/pkg/jwt"
+	"github.com/Dimoonevs/user-service/app/pkg/ratelimit"
 	"github.com/Dimoonevs/user-service/app/pkg/route"
 	"github.com/valyala/fasthttp"
 	"github.com/vharitonsky/iniflags"
+	"log"
 )
 
 var (
-	port = flag.String("port", "8080", "Port to listen on")
+	port            = flag.String("port", "8080", "Port to listen on")
+	encryptAITokens = flag.Bool("encryptAITokens", false, "Encrypt AI tokens stored in plaintext, then exit")
 )
 
 func main() {
 	iniflags.Parse()
+	if err := jwt.LoadKeys(); err != nil {
+		log.Fatalf("Error loading JWT keys: %v", err)
+	}
+	jwt.ReloadKeysOnSIGHUP()
+	jwt.SetRevocationChecker(route.IsTokenRevoked)
+	if err := route.CheckConfig(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := envelope.LoadKeys(); err != nil {
+		log.Fatalf("Error loading AI token keys: %v", err)
+	}
+	if *encryptAITokens {
+		if err := jobs.EncryptAITokens(); err != nil {
+			log.Fatalf("Error encrypting AI tokens: %v", err)
+		}
+		return
+	}
+	if err := ratelimit.LoadRules(); err != nil {
+		log.Fatalf("Error loading rate limit rules: %v", err)
+	}
 	metrics.InitAndStartMetricsServer()
+	jobs.Start()
+
+	go func() {
+		if err := extauthz.ListenAndServe(); err != nil {
+			log.Fatalf("Error starting ext_authz server: %v", err)
+		}
+	}()
+	go func() {
+		if err := internalapi.ListenAndServe(route.InternalRequestHandler); err != nil {
+			log.Fatalf("Error starting internal API server: %v", err)
+		}
+	}()
 
 	server := &fasthttp.Server{
 		Handler:            route.RequestHandler,
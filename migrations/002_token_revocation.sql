@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        VARCHAR(64) PRIMARY KEY,
+    user_id    INT         NOT NULL,
+    expires_at BIGINT      NOT NULL,
+    revoked_at DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY ix_revoked_tokens_expires_at (expires_at)
+);
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+    user_id        INT    PRIMARY KEY,
+    revoked_before BIGINT NOT NULL
+);
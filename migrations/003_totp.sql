@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id        INT         PRIMARY KEY,
+    secret         VARCHAR(64) NOT NULL,
+    is_confirmed   TINYINT(1)  NOT NULL DEFAULT 0,
+    last_used_step BIGINT      NOT NULL DEFAULT 0,
+    created_at     DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id        INT AUTO_INCREMENT PRIMARY KEY,
+    user_id   INT      NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at   DATETIME NULL,
+    UNIQUE KEY ux_user_recovery_codes_user_code (user_id, code_hash)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id                 INT AUTO_INCREMENT PRIMARY KEY,
+    user_id            INT          NOT NULL,
+    credential_id_hash CHAR(64)     NOT NULL,
+    credential         BLOB         NOT NULL,
+    name               VARCHAR(255) NOT NULL,
+    created_at         DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at       DATETIME     NULL,
+    UNIQUE KEY ux_webauthn_credentials_credential_id_hash (credential_id_hash),
+    KEY ix_webauthn_credentials_user_id (user_id)
+);
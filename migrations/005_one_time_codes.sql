@@ -0,0 +1,28 @@
+-- One-time codes replace the plaintext users.verification_token, scoped to a
+-- purpose and stored as an HMAC with expiry and an attempt limit.
+--
+-- Cutover: codes pending at deploy cannot be carried over, their HMAC needs
+-- the pepper the database does not have. They stop working and users ask for
+-- a new one with /code or /request/reset/password; the old column is emptied
+-- so no plaintext code stays behind. The column itself is kept for the
+-- previous release to roll back to and can be dropped once it is no longer
+-- needed:
+--     ALTER TABLE users DROP COLUMN verification_token;
+-- Rollback: DROP TABLE one_time_codes;
+CREATE TABLE IF NOT EXISTS one_time_codes (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT          NOT NULL,
+    purpose    VARCHAR(32)  NOT NULL,
+    code_hash  CHAR(64)     NOT NULL,
+    payload    VARCHAR(255) NOT NULL DEFAULT '',
+    attempts   INT          NOT NULL DEFAULT 0,
+    expires_at DATETIME     NOT NULL,
+    used_at    DATETIME     NULL,
+    created_at DATETIME     NOT NULL,
+    KEY ix_one_time_codes_user_purpose (user_id, purpose, used_at)
+);
+
+-- New users are inserted without a verification_token; the previous release
+-- reads it as a string, so it stays NOT NULL.
+ALTER TABLE users MODIFY verification_token VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE users SET verification_token = '';
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    name            VARCHAR(320) PRIMARY KEY,
+    failures        INT          NOT NULL DEFAULT 0,
+    last_failure_at BIGINT       NOT NULL DEFAULT 0,
+    locked_until    BIGINT       NOT NULL DEFAULT 0,
+    KEY ix_login_failures_last_failure_at (last_failure_at)
+);
@@ -0,0 +1,2 @@
+-- argon2id PHC strings are longer than the 60 characters of bcrypt.
+ALTER TABLE users MODIFY password_hash VARCHAR(255) NOT NULL;
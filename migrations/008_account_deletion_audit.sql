@@ -0,0 +1,23 @@
+ALTER TABLE users
+    ADD COLUMN deleted_at  DATETIME NULL,
+    ADD COLUMN purge_after DATETIME NULL,
+    ADD KEY ix_users_purge_after (purge_after);
+
+CREATE TABLE IF NOT EXISTS login_history (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT         NOT NULL,
+    method     VARCHAR(32) NOT NULL,
+    ip         VARCHAR(45) NOT NULL DEFAULT '',
+    success    TINYINT(1)  NOT NULL,
+    created_at DATETIME    NOT NULL,
+    KEY ix_login_history_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    user_id    INT          NOT NULL,
+    event      VARCHAR(64)  NOT NULL,
+    detail     VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME     NOT NULL,
+    KEY ix_audit_events_user_id (user_id)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE user_ai_settings
+    MODIFY COLUMN token TEXT NOT NULL,
+    ADD COLUMN token_key_id   VARCHAR(64) NULL,
+    ADD COLUMN token_data_key VARCHAR(128) NULL,
+    ADD KEY ix_user_ai_settings_token_key_id (token_key_id);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS service_access_log (
+    id          INT AUTO_INCREMENT PRIMARY KEY,
+    client_id   VARCHAR(128) NOT NULL,
+    auth_method VARCHAR(16)  NOT NULL,
+    action      VARCHAR(64)  NOT NULL,
+    user_id     INT          NOT NULL,
+    settings_id INT          NOT NULL,
+    ip          VARCHAR(45)  NOT NULL DEFAULT '',
+    success     TINYINT(1)   NOT NULL,
+    created_at  DATETIME     NOT NULL,
+    KEY ix_service_access_log_user_id (user_id),
+    KEY ix_service_access_log_client_id (client_id)
+);
@@ -0,0 +1,3 @@
+-- Lockouts since the last successful login or unlock; they outlive the
+-- failure window so that repeated lockouts get longer.
+ALTER TABLE login_failures ADD COLUMN lockouts INT NOT NULL DEFAULT 0 AFTER locked_until;
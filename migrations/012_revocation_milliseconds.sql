@@ -0,0 +1,3 @@
+-- Access tokens carry iat to the millisecond; logout-all cut-offs are stored
+-- in Unix milliseconds to match.
+UPDATE user_token_revocations SET revoked_before = revoked_before * 1000;
@@ -0,0 +1,39 @@
+#!/usr/bin/env bash
+# Creates the secrets that never leave the host. Run from the deploy
+# directory by restart.sh; files that exist already are left alone.
+set -euo pipefail
+umask 077
+
+# Master key for AI tokens, see aiTokenKeyFile.
+if [ ! -f ai_token_keys ]; then
+    echo "k1:$(openssl rand -base64 32)" > ai_token_keys
+fi
+
+# CA of the internal API. It signs the server certificate and the client
+# certificate of every backend service allowed to read AI tokens; copy
+# <client>.crt, <client>.key and ca.crt to that service.
+INTERNAL_TLS_SAN=${INTERNAL_TLS_SAN:-DNS:localhost,IP:127.0.0.1}
+INTERNAL_CLIENTS=${INTERNAL_CLIENTS:-video-service}
+
+mkdir -p internal_tls
+cd internal_tls
+
+if [ ! -f ca.crt ]; then
+    openssl req -x509 -newkey rsa:3072 -nodes -days 3650 \
+        -subj "/CN=user-service internal CA" -keyout ca.key -out ca.crt
+fi
+
+# issue <name> <common name> <extensions>
+issue() {
+    [ -f "$1.crt" ] && return
+    openssl req -newkey rsa:3072 -nodes -subj "/CN=$2" -keyout "$1.key" -out "$1.csr"
+    openssl x509 -req -in "$1.csr" -CA ca.crt -CAkey ca.key -CAcreateserial -days 825 \
+        -extfile <(printf '%s\n' "$3") -out "$1.crt"
+    rm "$1.csr"
+}
+
+issue server user-service "subjectAltName=$INTERNAL_TLS_SAN
+extendedKeyUsage=serverAuth"
+for client in $INTERNAL_CLIENTS; do
+    issue "$client" "$client" "extendedKeyUsage=clientAuth"
+done
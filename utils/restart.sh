@@ -1,5 +1,7 @@
 #!/usr/bin/env bash
 
+sudo bash init_secrets.sh || exit 1
+sudo ./user-service-linux-amd64 -config=./prod.ini -encryptAITokens || exit 1
 sudo pm2 stop user-service
 sudo GOMAXPROCS=3 pm2 start user-service-linux-amd64 --name=user-service -- -config=./prod.ini
-sudo pm2 save
\ No newline at end of file
+sudo pm2 save